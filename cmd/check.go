@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check [file or glob...]",
+	Short: "Validate every envelope in the provided files (or standard in)",
+	Long: `Validate every envelope in the provided files, or standard in when no files are given.
+
+By default the check is structural: every envelope is decoded offline and text that
+looks like the start of an envelope but is not a complete one is reported. With
+--online each envelope is also decrypted, and the plaintext discarded, to prove it
+can be opened with the current credentials.
+
+Every failure is reported with its file, line and column and the command exits with
+a non-zero status if anything failed, which makes it suitable for pre-merge pipelines.
+Globs are expanded by dragoman, and "**" matches any number of directories.
+
+Examples:
+
+dragoman check 'config/**/*.yaml'
+dragoman check --online config/prod.yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		online, err := cmd.Flags().GetBool("online")
+		if err != nil {
+			panic(err)
+		}
+
+		cfg := &checkConfig{
+			Out:    os.Stdout,
+			Online: online,
+		}
+
+		if cfg.Online {
+			cfg.Strategy = newDecryptionStrategy()
+		}
+
+		if len(args) == 0 {
+			cfg.Stdin = os.Stdin
+		} else if cfg.Files, err = expandGlobs(args); err != nil {
+			panic(err)
+		}
+
+		var failures int
+		if failures, err = processCheck(cfg); err != nil {
+			panic(err)
+		}
+
+		if failures > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().Bool("online", false, "Also decrypt every envelope (discarding the plaintext) to prove it can be opened")
+}
+
+type checkConfig struct {
+	Stdin    io.Reader
+	Files    []string
+	Out      io.Writer
+	Online   bool
+	Strategy cryptography.Decryptor
+}
+
+// processCheck validates the configured inputs, reporting every failure, and returns the number of failures
+func processCheck(cfg *checkConfig) (int, error) {
+	var checked, failures int
+
+	check := func(fname string, in io.Reader) error {
+		contents, err := io.ReadAll(in)
+		if err != nil {
+			return fmt.Errorf("unable to read \"%s\": %v", fname, err)
+		}
+
+		for _, broken := range cryptography.FindBrokenEnvelopes(string(contents)) {
+			failures++
+			fmt.Fprintf(cfg.Out, "%s:%d:%d: %s: malformed envelope\n", fname, broken.Line, broken.Column, broken.Type)
+		}
+
+		for _, envelope := range cryptography.FindEnvelopes(string(contents)) {
+			checked++
+
+			if err = checkEnvelope(envelope, cfg); err != nil {
+				failures++
+				fmt.Fprintf(cfg.Out, "%s:%d:%d: %s: %v\n", fname, envelope.Line, envelope.Column, envelope.Type, err)
+			}
+		}
+
+		return nil
+	}
+
+	if cfg.Stdin != nil {
+		if err := check("<stdin>", cfg.Stdin); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, fname := range cfg.Files {
+		file, err := os.Open(fname)
+		if err != nil {
+			return 0, fmt.Errorf("unable to open file \"%s\": %v", fname, err)
+		}
+
+		err = check(fname, file)
+		file.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	fmt.Fprintf(cfg.Out, "checked %d envelope(s), %d failure(s)\n", checked, failures)
+
+	return failures, nil
+}
+
+func checkEnvelope(envelope cryptography.Envelope, cfg *checkConfig) error {
+	if _, err := cryptography.InspectEnvelope(envelope.Value()); err != nil {
+		return err
+	}
+
+	if cfg.Online {
+		if _, err := cfg.Strategy.Decrypt(envelope.Value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProcessCheck(t *testing.T) {
+	t.Run("it should report malformed envelopes without calling AWS", func(t *testing.T) {
+		mocks := mockAws(t)
+		valid := kmsEnvelope(t, new(kmsClientMock).withKey(testKeyArn, testKeyArn), testKeyArn, "hunter2", nil)
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("a: "+valid+"\nb: ENC[KMS,trunc\nc: ENC[KMS,bm90IGdvYg==]\n"), 0600))
+
+		out := &bytes.Buffer{}
+		failures, err := processCheck(&checkConfig{Files: []string{file}, Out: out})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, failures)
+		assert.Equal(t, []string{
+			file + ":2:4: KMS: malformed envelope",
+			file + ":3:4: KMS: failed to decode the message payload: unexpected EOF",
+			"checked 2 envelope(s), 2 failure(s)",
+		}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+		assert.Empty(t, mocks.kmsClients)
+	})
+
+	t.Run("it should decrypt every envelope online", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("").withKey(testKeyArn, testKeyArn)
+		mocks.sm("").withSecret("app/db", "hunter2").withMissingSecrets()
+
+		dir := t.TempDir()
+		first := filepath.Join(dir, "first.yaml")
+		second := filepath.Join(dir, "second.yaml")
+		assert.Nil(t, os.WriteFile(first, []byte("a: "+kmsEnvelope(t, mocks.kms(""), testKeyArn, "hunter2", nil)+"\n"), 0600))
+		assert.Nil(t, os.WriteFile(second, []byte("b: "+smEnvelope(t, "app/db", nil)+"\nc: "+smEnvelope(t, "app/gone", nil)+"\n"), 0600))
+
+		out := &bytes.Buffer{}
+		failures, err := processCheck(&checkConfig{
+			Stdin:    strings.NewReader("no envelopes here\n"),
+			Files:    []string{first, second},
+			Out:      out,
+			Online:   true,
+			Strategy: newDecryptionStrategy(),
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, failures)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Len(t, lines, 2)
+		assert.True(t, strings.HasPrefix(lines[0], second+":2:4: SECMAN: "), lines[0])
+		assert.Equal(t, "checked 3 envelope(s), 1 failure(s)", lines[1])
+		mocks.kms("").AssertNumberOfCalls(t, "Decrypt", 1)
+	})
+
+	t.Run("it should hold online checks to the policy", func(t *testing.T) {
+		mocks := mockAws(t)
+
+		policy := decryptionPolicy
+		t.Cleanup(func() { decryptionPolicy = policy })
+		decryptionPolicy = &cryptography.Policy{SecretIds: []string{"app/*"}}
+
+		out := &bytes.Buffer{}
+		failures, err := processCheck(&checkConfig{
+			Stdin:    strings.NewReader("a: " + smEnvelope(t, "prod/payments/db", nil) + "\n"),
+			Out:      out,
+			Online:   true,
+			Strategy: newDecryptionStrategy(),
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, failures)
+		assert.Equal(t, "<stdin>:1:4: SECMAN: the secret \"prod/payments/db\" is not allowed by the policy\nchecked 1 envelope(s), 1 failure(s)\n", out.String())
+		mocks.sm("").AssertNotCalled(t, "GetSecretValue", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should fail on files that cannot be read", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+		_, err := processCheck(&checkConfig{Files: []string{missing}, Out: &bytes.Buffer{}})
+
+		assert.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "unable to open file \""+missing+"\""))
+	})
+}
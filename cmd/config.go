@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// projectConfigName is the repository config file, found by walking up from the current directory
+const projectConfigName = ".dragoman.yaml"
+
+// projectConfig is the content of a .dragoman.yaml file
+type projectConfig struct {
+	CreationRules []creationRule `yaml:"creation_rules"`
+
+	dir string // The directory of the config file, rule paths are relative to it
+}
+
+// creationRule picks the key new envelopes are encrypted with. A rule applies when its path glob matches the file
+// being encrypted and its environment matches --environment, an empty path or environment matches anything.
+type creationRule struct {
+	Path        string `yaml:"path"`
+	Environment string `yaml:"environment"`
+	Strategy    string `yaml:"strategy"` // Only kms for now, the default
+	Key         string `yaml:"key"`
+	Region      string `yaml:"region"`
+}
+
+// loadProjectConfig finds and parses the closest .dragoman.yaml, returning nil when there is none
+func loadProjectConfig() (*projectConfig, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find %s: %v", projectConfigName, err)
+	}
+
+	for {
+		fname := filepath.Join(dir, projectConfigName)
+
+		file, err := os.Open(fname)
+		if err == nil {
+			defer file.Close()
+			return parseProjectConfig(file, fname)
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unable to open \"%s\": %v", fname, err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+func parseProjectConfig(file *os.File, fname string) (*projectConfig, error) {
+	config := &projectConfig{dir: filepath.Dir(fname)}
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to parse \"%s\": %v", fname, err)
+	}
+
+	for i, rule := range config.CreationRules {
+		if rule.Strategy != "" && !strings.EqualFold(rule.Strategy, "kms") {
+			return nil, fmt.Errorf("%s: creation rule %d: unsupported strategy \"%s\", only kms is supported", fname, i+1, rule.Strategy)
+		}
+
+		if rule.Key == "" {
+			return nil, fmt.Errorf("%s: creation rule %d: a key is required", fname, i+1)
+		}
+	}
+
+	return config, nil
+}
+
+// ruleFor returns the first creation rule that applies to the file and environment, or nil.
+// file may be empty when the input is not a file, only rules without a path apply then.
+func (c *projectConfig) ruleFor(file string, environment string) *creationRule {
+	var rel string
+	if file != "" {
+		if abs, err := filepath.Abs(file); err == nil {
+			if rel, err = filepath.Rel(c.dir, abs); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				rel = ""
+			}
+		}
+	}
+
+	for i, rule := range c.CreationRules {
+		if rule.Environment != "" && rule.Environment != environment {
+			continue
+		}
+
+		if rule.Path != "" && (rel == "" || !matchGlob(filepath.ToSlash(rule.Path), filepath.ToSlash(rel))) {
+			continue
+		}
+
+		return &c.CreationRules[i]
+	}
+
+	return nil
+}
+
+// addEnvironmentFlag registers the flag that selects creation rules by environment
+func addEnvironmentFlag(cmd *cobra.Command) {
+	cmd.Flags().String("environment", os.Getenv("DRAGOMAN_ENVIRONMENT"), "The environment used to pick a creation rule of "+projectConfigName+". Defaults to $DRAGOMAN_ENVIRONMENT")
+}
+
+// resolveKmsKey picks the KMS key and region to encrypt a file with. A key or region given on the command line wins,
+// then the first matching creation rule of .dragoman.yaml, then the flag defaults taken from environment variables.
+func resolveKmsKey(cmd *cobra.Command, keyFlag string, config *projectConfig, file string) (string, string, error) {
+	key, err := cmd.Flags().GetString(keyFlag)
+	if err != nil {
+		return "", "", err
+	}
+
+	region, err := cmd.Flags().GetString("aws-region")
+	if err != nil {
+		return "", "", err
+	}
+
+	if config == nil {
+		return key, region, nil
+	}
+
+	environment, _ := cmd.Flags().GetString("environment")
+
+	rule := config.ruleFor(file, environment)
+	if rule == nil {
+		return key, region, nil
+	}
+
+	if !cmd.Flags().Changed(keyFlag) {
+		key = rule.Key
+	}
+
+	if !cmd.Flags().Changed("aws-region") && rule.Region != "" {
+		region = rule.Region
+	}
+
+	return key, region, nil
+}
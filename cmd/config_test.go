@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+const testProjectConfig = `creation_rules:
+  - path: envs/prod/*.yaml
+    key: alias/prod
+    region: eu-west-1
+  - path: "**/secrets.yaml"
+    environment: staging
+    key: alias/staging-secrets
+  - path: "**/secrets.yaml"
+    key: alias/secrets
+  - environment: staging
+    key: alias/staging
+  - key: alias/default
+`
+
+// chdirProject writes the config to a temporary project and moves into the subdirectory of it for the test
+func chdirProject(t *testing.T, config string, subdir string) string {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, subdir), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, projectConfigName), []byte(config), 0644))
+
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	assert.Nil(t, os.Chdir(filepath.Join(root, subdir)))
+
+	return root
+}
+
+func TestLoadProjectConfig(t *testing.T) {
+	t.Run("it should find the config of a parent directory", func(t *testing.T) {
+		root := chdirProject(t, testProjectConfig, "envs/prod")
+
+		config, err := loadProjectConfig()
+
+		assert.Nil(t, err)
+		assert.Equal(t, root, config.dir)
+		assert.Len(t, config.CreationRules, 5)
+		assert.Equal(t, "eu-west-1", config.CreationRules[0].Region)
+	})
+
+	t.Run("it should refuse invalid rules", func(t *testing.T) {
+		for config, expected := range map[string]string{
+			"creation_rules:\n  - path: '*.yaml'\n":                        "creation rule 1: a key is required",
+			"creation_rules:\n  - key: a\n  - key: b\n    strategy: gpg\n": "creation rule 2: unsupported strategy \"gpg\", only kms is supported",
+			"creation_rules:\n  - key: a\n    kms_key: b\n":                "field kms_key not found",
+		} {
+			root := chdirProject(t, config, "")
+
+			_, err := loadProjectConfig()
+
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), filepath.Join(root, projectConfigName))
+			assert.Contains(t, err.Error(), expected)
+		}
+	})
+}
+
+func TestRuleFor(t *testing.T) {
+	root := chdirProject(t, testProjectConfig, "envs")
+
+	config, err := loadProjectConfig()
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		name        string
+		file        string
+		environment string
+		expected    string
+	}{
+		{"paths are relative to the config directory", "prod/values.yaml", "", "alias/prod"},
+		{"absolute paths are made relative too", filepath.Join(root, "envs/prod/values.yaml"), "", "alias/prod"},
+		{"a glob segment does not match several directories", "prod/nested/values.yaml", "", "alias/default"},
+		{"** matches any number of directories", "prod/nested/secrets.yaml", "", "alias/secrets"},
+		{"** matches no directory at all", "../secrets.yaml", "", "alias/secrets"},
+		{"the environment must match when a rule has one", "dev/secrets.yaml", "staging", "alias/staging-secrets"},
+		{"the first matching rule wins", "dev/secrets.yaml", "prod", "alias/secrets"},
+		{"rules without a path apply to any file", "dev/values.yaml", "staging", "alias/staging"},
+		{"files outside the project only match rules without a path", "../../outside/secrets.yaml", "", "alias/default"},
+		{"standard in only matches rules without a path", "", "staging", "alias/staging"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rule := config.ruleFor(test.file, test.environment)
+
+			assert.NotNil(t, rule)
+			assert.Equal(t, test.expected, rule.Key)
+		})
+	}
+
+	t.Run("it should return nil when no rule applies", func(t *testing.T) {
+		config := &projectConfig{dir: root, CreationRules: []creationRule{{Path: "*.json", Key: "alias/json"}}}
+
+		assert.Nil(t, config.ruleFor("values.yaml", ""))
+	})
+}
+
+func TestResolveKmsKey(t *testing.T) {
+	root := chdirProject(t, testProjectConfig, "")
+
+	config, err := loadProjectConfig()
+	assert.Nil(t, err)
+
+	t.Setenv("DRAGOMAN_ENVIRONMENT", "")
+
+	// The flag defaults stand for $KMS_KEY_ID and $AWS_REGION
+	command := func(flags ...string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("kms-key-id", "alias/from-env", "")
+		cmd.Flags().String("aws-region", "us-east-1", "")
+		addEnvironmentFlag(cmd)
+		assert.Nil(t, cmd.Flags().Parse(flags))
+
+		return cmd
+	}
+
+	for _, test := range []struct {
+		name           string
+		flags          []string
+		config         *projectConfig
+		file           string
+		expectedKey    string
+		expectedRegion string
+	}{
+		{"the rule wins over the environment", nil, config, "envs/prod/values.yaml", "alias/prod", "eu-west-1"},
+		{"the environment is kept when the rule has no region", nil, config, "secrets.yaml", "alias/secrets", "us-east-1"},
+		{"flags win over the rule", []string{"--kms-key-id", "alias/flag", "--aws-region", "ap-south-1"}, config, "envs/prod/values.yaml", "alias/flag", "ap-south-1"},
+		{"the environment flag picks the rule", []string{"--environment", "staging"}, config, "values.yaml", "alias/staging", "us-east-1"},
+		{"the environment is used without a config", nil, nil, "envs/prod/values.yaml", "alias/from-env", "us-east-1"},
+		{"the environment is used when no rule applies", nil, &projectConfig{dir: root}, "values.yaml", "alias/from-env", "us-east-1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			key, region, err := resolveKmsKey(command(test.flags...), "kms-key-id", test.config, test.file)
+
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectedKey, key)
+			assert.Equal(t, test.expectedRegion, region)
+		})
+	}
+
+	t.Run("it should pick the rule of $DRAGOMAN_ENVIRONMENT", func(t *testing.T) {
+		t.Setenv("DRAGOMAN_ENVIRONMENT", "staging")
+
+		key, _, err := resolveKmsKey(command(), "kms-key-id", config, "values.yaml")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "alias/staging", key)
+
+		key, _, err = resolveKmsKey(command("--environment", "prod"), "kms-key-id", config, "values.yaml")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "alias/default", key)
+	})
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/meltwater/dragoman/formats"
+	"github.com/spf13/cobra"
+)
+
+// convertCmd represents the convert command
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Move the secrets of a file between inline KMS values and Secrets Manager references",
+	Long: `Convert the envelopes of a YAML, JSON, TOML or dotenv file between the two ways of keeping secrets.
+
+--to secman stores the plaintext of every ENC[KMS,...] value in a Secrets Manager secret named
+after --sm-prefix and the dotted path of the value, and puts an ENC[SECMAN,...] reference in its
+place. Secrets that already hold the value are left as they are, so the conversion can be run
+again after a failure. An existing secret holding a different value is only replaced with --overwrite.
+
+--to kms reads the secret of every ENC[SECMAN,...] reference and puts an ENC[KMS,...] value in
+its place, encrypted with --kms-key-id or the key of the first creation rule of .dragoman.yaml.
+
+Secrets Manager is used in --aws-region. KMS values are decrypted in the region of the key
+they record, and encrypted in the region of the creation rule when it has one.
+
+Only values that are a single envelope are converted, everything else is left untouched.
+
+Examples:
+
+Move the KMS values of a file to Secrets Manager, as app/db.password and so on
+dragoman convert --to secman --sm-prefix app/ --file values.yaml --in-place
+
+Inline the Secrets Manager references of a file as KMS values
+dragoman convert --to kms --kms-key-id alias/app --file values.yaml -o values.kms.yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		format, _ := cmd.Flags().GetString("format")
+		to, _ := cmd.Flags().GetString("to")
+		prefix, _ := cmd.Flags().GetString("sm-prefix")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+
+		if file == "" {
+			panic(fmt.Errorf("the file to convert must be provided with --file"))
+		}
+
+		// Standard out, --output or --in-place, only written once every value has been converted
+		output, err := newCommandOutput(cmd, file, false)
+		if err != nil {
+			panic(err)
+		}
+
+		awsRegion, _ := cmd.Flags().GetString("aws-region")
+
+		config, err := loadProjectConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		cfg := &convertConfig{
+			Out:       output,
+			Log:       os.Stderr,
+			File:      file,
+			Format:    format,
+			To:        strings.ToLower(to),
+			SmPrefix:  prefix,
+			Overwrite: overwrite,
+			AwsRegion: awsRegion,
+			Variables: referenceVariables,
+			Policy:    decryptionPolicy,
+		}
+
+		// The region of a creation rule is the region of its key, which only matters when encrypting with it
+		if cfg.KmsKey, cfg.KmsRegion, err = resolveKmsKey(cmd, "kms-key-id", config, file); err != nil {
+			panic(err)
+		}
+
+		if cfg.Metadata, err = newEnvelopeMetadata(cmd, cfg.AwsRegion); err != nil {
+			panic(err)
+		}
+
+		if err = processConvert(cfg); err != nil {
+			panic(err)
+		}
+
+		if err = output.Commit(); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+
+	convertCmd.Flags().String("to", "", "What to convert the envelopes to: secman or kms")
+	convertCmd.Flags().String("file", "", "The YAML, JSON, TOML or dotenv file to convert")
+	convertCmd.Flags().String("format", "", "The format of --file (yaml, json, toml or dotenv), detected from the file name by default")
+	convertCmd.Flags().String("sm-prefix", "", "With --to secman, the prefix of the secret names, for example app/")
+	convertCmd.Flags().Bool("overwrite", false, "With --to secman, replace existing secrets that hold a different value")
+	convertCmd.Flags().String("kms-key-id", os.Getenv("KMS_KEY_ID"), "With --to kms, the KMS key to encrypt the values with")
+	convertCmd.Flags().String("aws-region", getFirstEnv("AWS_REGION", "AWS_DEFAULT_REGION"), "Provides the AWS region to use for Secrets Manager, and for KMS values that do not record their key")
+	addOutputFlags(convertCmd)
+	addEnvironmentFlag(convertCmd)
+	addMetadataFlags(convertCmd)
+}
+
+type convertConfig struct {
+	Out       io.Writer
+	Log       io.Writer
+	File      string
+	Format    string
+	To        string
+	SmPrefix  string // --to secman specific
+	Overwrite bool   // --to secman specific
+	KmsKey    string // --to kms specific
+	KmsRegion string // --to kms specific, the region of KmsKey, AwsRegion when empty
+	AwsRegion string
+	Variables *cryptography.ReferenceVariables
+	Policy    *cryptography.Policy           // Envelopes outside it are not converted
+	Metadata  *cryptography.EnvelopeMetadata // Recorded in the new envelopes
+}
+
+func processConvert(cfg *convertConfig) error {
+	var err error
+
+	switch cfg.To {
+	case "secman":
+		if cfg.SmPrefix == "" {
+			return fmt.Errorf("the prefix of the secret names must be provided with --sm-prefix")
+		}
+	case "kms":
+		if cfg.KmsKey == "" {
+			return fmt.Errorf("a KMS key must be provided with --kms-key-id or a creation rule in %s", projectConfigName)
+		}
+	default:
+		return fmt.Errorf("unknown conversion \"%s\", --to must be secman or kms", cfg.To)
+	}
+
+	if cfg.AwsRegion == "" {
+		return fmt.Errorf("an aws region must be provided for KMS and Secrets Manager")
+	}
+
+	var format formats.Format
+	if cfg.Format != "" {
+		format, err = formats.ParseFormat(cfg.Format)
+	} else {
+		format, err = formats.DetectFormat(cfg.File)
+	}
+	if err != nil {
+		return err
+	}
+
+	var contents []byte
+	if contents, err = os.ReadFile(cfg.File); err != nil {
+		return fmt.Errorf("unable to read file \"%s\": %v", cfg.File, err)
+	}
+
+	var values []formats.Value
+	if values, err = formats.FindValues(format, string(contents)); err != nil {
+		return fmt.Errorf("unable to read \"%s\": %v", cfg.File, err)
+	}
+
+	var convert func(value formats.Value, envelope cryptography.Envelope) (string, error)
+	var from string
+
+	if cfg.To == "secman" {
+		from = cryptography.CRYPTO_KEY_KMS
+		if convert, err = kmsToSecman(cfg); err != nil {
+			return err
+		}
+	} else {
+		from = cryptography.CRYPTO_KEY_SM
+		if convert, err = secmanToKms(cfg); err != nil {
+			return err
+		}
+	}
+
+	converted := 0
+	output, err := formats.ReplaceValues(string(contents), values, func(value formats.Value) (string, bool, error) {
+		envelopes := cryptography.FindEnvelopes(value.Text)
+		if len(envelopes) == 0 || envelopes[0].Type != from {
+			return "", false, nil
+		}
+
+		// Values holding envelopes among other text have no secret of their own to convert
+		if len(envelopes) > 1 || envelopes[0].Start != 0 || envelopes[0].End != len(value.Text) {
+			fmt.Fprintf(cfg.Log, "warning: \"%s\" holds more than a single envelope and was left as it is\n", value.Key())
+			return "", false, nil
+		}
+
+		// Converting reads the secret, so planted envelopes are held to the policy like when decrypting
+		if err := cfg.Policy.CheckEnvelope(envelopes[0].Value()); err != nil {
+			return "", false, fmt.Errorf("unable to convert \"%s\": %v", value.Key(), err)
+		}
+
+		replacement, err := convert(value, envelopes[0])
+		if err != nil {
+			return "", false, fmt.Errorf("unable to convert \"%s\": %v", value.Key(), err)
+		}
+
+		converted++
+
+		return replacement, true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if converted == 0 {
+		fmt.Fprintf(cfg.Log, "warning: no %s values were found in \"%s\"\n", from, cfg.File)
+	}
+
+	cfg.Out.Write([]byte(output))
+
+	return nil
+}
+
+// kmsToSecman stores the plaintext of a KMS envelope in a secret named after the value and returns a reference to it
+func kmsToSecman(cfg *convertConfig) (func(formats.Value, cryptography.Envelope) (string, error), error) {
+	sm, err := newSecretsManagerStrategy(cfg.AwsRegion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create secrets manager crypto strategy: %v", err)
+	}
+
+	strategies := kmsStrategies{}
+
+	return func(value formats.Value, envelope cryptography.Envelope) (string, error) {
+		kms, err := strategies.forEnvelope(envelope.Value(), cfg.AwsRegion)
+		if err != nil {
+			return "", err
+		}
+
+		plaintext, err := kms.WithPolicy(cfg.Policy).Decrypt(envelope.Value())
+		if err != nil {
+			return "", err
+		}
+
+		name := cfg.SmPrefix + value.Key()
+
+		// A path that collides with a live secret must not silently replace its value
+		written, err := sm.PutNewSecret(name, plaintext, "", cfg.Overwrite)
+		if errors.Is(err, cryptography.ErrSecretExists) {
+			return "", fmt.Errorf("%v, use --overwrite to replace it", err)
+		} else if err != nil {
+			return "", err
+		}
+
+		switch written {
+		case cryptography.SecretCreated:
+			fmt.Fprintf(cfg.Log, "created the secret \"%s\"\n", name)
+		case cryptography.SecretUpdated:
+			fmt.Fprintf(cfg.Log, "replaced the value of the secret \"%s\"\n", name)
+		}
+
+		return sm.WithMetadata(movedMetadata(envelopeMetadata(envelope), cfg.Metadata)).Encrypt([]byte(name), "")
+	}, nil
+}
+
+// secmanToKms reads the secret of a Secrets Manager reference and returns it as a KMS envelope.
+// All the values share one data key, so the file costs a single KMS call.
+func secmanToKms(cfg *convertConfig) (func(formats.Value, cryptography.Envelope) (string, error), error) {
+	kmsRegion := cfg.KmsRegion
+	if kmsRegion == "" {
+		kmsRegion = cfg.AwsRegion
+	}
+
+	kms, err := newKmsStrategy(kmsRegion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kms crypto strategy: %v", err)
+	}
+
+	sm, err := newSecretsManagerStrategy(cfg.AwsRegion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create secrets manager crypto strategy: %v", err)
+	}
+
+	kms = kms.WithDataKeyReuse()
+	sm = sm.WithReferenceVariables(cfg.Variables).WithPolicy(cfg.Policy)
+
+	return func(value formats.Value, envelope cryptography.Envelope) (string, error) {
+		plaintext, err := sm.Decrypt(envelope.Value())
+		if err != nil {
+			return "", err
+		}
+
+		return kms.WithMetadata(movedMetadata(envelopeMetadata(envelope), cfg.Metadata)).Encrypt(plaintext, cfg.KmsKey)
+	}, nil
+}
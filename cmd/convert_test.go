@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestConvertPolicy(t *testing.T) {
+	t.Run("it should refuse to convert references outside the policy", func(t *testing.T) {
+		mocks := mockAws(t)
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("db:\n  password: "+smEnvelope(t, "prod/payments/db", nil)+"\n"), 0600))
+
+		out := &bytes.Buffer{}
+		err := processConvert(&convertConfig{
+			Out:       out,
+			Log:       &bytes.Buffer{},
+			File:      file,
+			To:        "kms",
+			KmsKey:    "alias/app",
+			AwsRegion: "us-east-1",
+			Policy:    &cryptography.Policy{SecretIds: []string{"prod/app/*"}},
+		})
+
+		assert.EqualError(t, err, `unable to convert "db.password": the secret "prod/payments/db" is not allowed by the policy`)
+		assert.Equal(t, 0, out.Len())
+		mocks.sm("us-east-1").AssertNotCalled(t, "GetSecretValue", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestConvertMetadata(t *testing.T) {
+	expires := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	original := &cryptography.EnvelopeMetadata{
+		Created: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Creator: "arn:aws:iam::123456789012:user/alice",
+		Expires: &expires,
+		Note:    "rotated quarterly",
+	}
+	given := &cryptography.EnvelopeMetadata{
+		Created: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC),
+		Creator: "arn:aws:iam::123456789012:user/bob",
+	}
+
+	t.Run("it should keep the metadata of KMS values moved to Secrets Manager", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("us-east-1").withKey(testKeyArn, testKeyArn)
+		mocks.sm("us-east-1").withMissingSecrets().
+			On("CreateSecret", context.TODO(), mock.Anything, mock.Anything).Return(&sm.CreateSecretOutput{}, nil)
+
+		withAlias := *original
+		withAlias.KeyAlias = "alias/app"
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("password: "+kmsEnvelope(t, mocks.kms("us-east-1"), testKeyArn, "hunter2", &withAlias)+"\n"), 0600))
+
+		out := &bytes.Buffer{}
+		assert.Nil(t, processConvert(&convertConfig{
+			Out:       out,
+			Log:       &bytes.Buffer{},
+			File:      file,
+			To:        "secman",
+			SmPrefix:  "app/",
+			AwsRegion: "us-east-1",
+			Metadata:  given,
+		}))
+
+		envelopes := cryptography.FindEnvelopes(out.String())
+		assert.Len(t, envelopes, 1)
+
+		info, err := cryptography.InspectEnvelope(envelopes[0].Value())
+		assert.Nil(t, err)
+		assert.Equal(t, "app/password", info.SecretId)
+		assert.Equal(t, original, info.Metadata, "everything but the key alias is kept")
+	})
+
+	t.Run("it should keep the metadata of Secrets Manager references moved to KMS, apart from what was given", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("us-east-1").withKey("alias/app", testKeyArn)
+		mocks.sm("us-east-1").withSecret("app/password", "hunter2")
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("password: "+smEnvelope(t, "app/password", original)+"\n"), 0600))
+
+		noted := *given
+		noted.Note = "inlined"
+
+		out := &bytes.Buffer{}
+		assert.Nil(t, processConvert(&convertConfig{
+			Out:       out,
+			Log:       &bytes.Buffer{},
+			File:      file,
+			To:        "kms",
+			KmsKey:    "alias/app",
+			AwsRegion: "us-east-1",
+			Metadata:  &noted,
+		}))
+
+		envelopes := cryptography.FindEnvelopes(out.String())
+		assert.Len(t, envelopes, 1)
+
+		info, err := cryptography.InspectEnvelope(envelopes[0].Value())
+		assert.Nil(t, err)
+		assert.Equal(t, &cryptography.EnvelopeMetadata{
+			Created:  original.Created,
+			Creator:  original.Creator,
+			Expires:  &expires,
+			KeyAlias: "alias/app",
+			Note:     "inlined",
+		}, info.Metadata)
+	})
+}
+
+func TestConvertRegions(t *testing.T) {
+	const ruleKeyArn = "arn:aws:kms:eu-west-1:123456789012:key/ruleKey"
+
+	t.Run("it should decrypt KMS values in the region of their key and keep Secrets Manager in --aws-region", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("eu-west-1").withKey(ruleKeyArn, ruleKeyArn)
+		mocks.sm("us-east-1").withMissingSecrets().
+			On("CreateSecret", context.TODO(), mock.Anything, mock.Anything).Return(&sm.CreateSecretOutput{}, nil)
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("password: "+kmsEnvelope(t, mocks.kms("eu-west-1"), ruleKeyArn, "hunter2", nil)+"\n"), 0600))
+
+		assert.Nil(t, processConvert(&convertConfig{
+			Out:       &bytes.Buffer{},
+			Log:       &bytes.Buffer{},
+			File:      file,
+			To:        "secman",
+			SmPrefix:  "app/",
+			AwsRegion: "us-east-1",
+		}))
+
+		mocks.sm("us-east-1").AssertCalled(t, "CreateSecret", context.TODO(), mock.Anything, mock.Anything)
+		assert.NotContains(t, mocks.smClients, "eu-west-1")
+	})
+
+	t.Run("it should only encrypt in the region of the creation rule", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("eu-west-1").withKey("alias/app", ruleKeyArn)
+		mocks.sm("us-east-1").withSecret("app/password", "hunter2")
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("password: "+smEnvelope(t, "app/password", nil)+"\n"), 0600))
+
+		out := &bytes.Buffer{}
+		assert.Nil(t, processConvert(&convertConfig{
+			Out:       out,
+			Log:       &bytes.Buffer{},
+			File:      file,
+			To:        "kms",
+			KmsKey:    "alias/app",
+			KmsRegion: "eu-west-1",
+			AwsRegion: "us-east-1",
+		}))
+
+		info, err := cryptography.InspectEnvelope(cryptography.FindEnvelopes(out.String())[0].Value())
+		assert.Nil(t, err)
+		assert.Equal(t, ruleKeyArn, info.KeyId)
+		assert.NotContains(t, mocks.smClients, "eu-west-1")
+		assert.NotContains(t, mocks.kmsClients, "us-east-1")
+	})
+}
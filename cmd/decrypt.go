@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/meltwater/dragoman/cryptography"
+	"github.com/meltwater/dragoman/formats"
 	"github.com/spf13/cobra"
 )
 
@@ -15,38 +16,98 @@ var decryptCmd = &cobra.Command{
 	Short: "Decrypt the provided string (via standard in or the file flag)",
 	Long: `Automatically decrypt the string provided by standard in
 
-The decryption strategy will be automatically detected`,
+The decryption strategy will be automatically detected.
+
+The output goes to standard out, to --output or replaces the --input file with --in-place.
+Files are replaced atomically and created with mode 0600 since they hold plaintext.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var input io.Reader = os.Stdin
-		var output io.Writer = os.Stdout
 
 		// File input
-		if fname, err := cmd.Flags().GetString("input"); err == nil && fname != "" {
-			var ferr error
-			if input, ferr = os.Open(fname); ferr != nil {
+		fname, _ := cmd.Flags().GetString("input")
+		if fname != "" {
+			file, ferr := os.Open(fname)
+			if ferr != nil {
 				panic(fmt.Errorf("unable to open file \"%s\": %v", fname, ferr))
 			}
+			defer file.Close()
+
+			input = file
 		}
 
-		// File output
-		if fname, err := cmd.Flags().GetString("output"); err == nil && fname != "" {
-			var ferr error
-			if output, ferr = os.Create(fname); ferr != nil {
-				panic(fmt.Errorf("unable to create output file \"%s\": %v", fname, ferr))
-			}
+		// Escape the decrypted values for the syntax of the file they are written into
+		escaping, _ := cmd.Flags().GetString("escape")
+		if escaping == "auto" {
+			escaping = formats.DetectEscaping(fname)
+		}
+
+		escaper, err := formats.NewEscaper(escaping)
+		if err != nil {
+			panic(err)
+		}
+
+		// What to do with envelopes that cannot be decrypted
+		onError, _ := cmd.Flags().GetString("on-error")
+		mode, err := cryptography.ParseOnError(onError)
+		if err != nil {
+			panic(err)
+		}
+
+		marker, _ := cmd.Flags().GetString("error-marker")
+
+		// What to do with envelopes that expired according to their metadata
+		onExpired, _ := cmd.Flags().GetString("on-expired")
+		expiry, err := cryptography.ParseOnExpired(onExpired)
+		if err != nil {
+			panic(err)
+		}
+
+		// Standard out, --output or --in-place, only written once decryption is done
+		output, err := newCommandOutput(cmd, fname, true)
+		if err != nil {
+			panic(err)
 		}
 
-		// Be able to handle different encryption types
-		strategy, err := cryptography.NewWildcardDecryptionStrategy([]cryptography.StrategyBuilder{
-			func() (cryptography.Decryptor, error) { return cryptography.NewKmsCryptoStrategy("") },
-			func() (cryptography.Decryptor, error) { return cryptography.NewSecretsManagerCryptoStrategy("") },
-		})
+		// Be able to handle different encryption types, leaving any skipped ones untouched
+		only, _ := cmd.Flags().GetStringSlice("only")
+		skip, _ := cmd.Flags().GetStringSlice("skip")
 
+		skipped, err := strategyFilter(only, skip)
 		if err != nil {
-			panic(fmt.Errorf("unable to setup the decryption strategies: %v", err))
+			panic(err)
 		}
 
-		if err := processDecrypt(input, output, strategy); err != nil {
+		strategy := newDecryptionStrategy().Skip(skipped...)
+
+		// A partial output would replace the envelopes that failed, with a marker or with nothing at all
+		replacesInput := output.Replaces(fname)
+
+		if fname == "" {
+			fname = "<stdin>"
+		}
+
+		options := cryptography.DecryptOptions{OnError: mode, Marker: marker, OnExpired: expiry, Warn: expiryWarning(fname)}
+
+		err = processDecrypt(input, output, strategy, escaper, options)
+		if _, partial := err.(cryptography.DecryptErrors); err == nil || (partial && !replacesInput) {
+			if cerr := output.Commit(); cerr != nil {
+				panic(cerr)
+			}
+		}
+
+		if failures, ok := err.(cryptography.DecryptErrors); ok {
+			// List where every failure is, the error only summarises them
+			for _, failure := range failures {
+				fmt.Fprintf(os.Stderr, "%s:%d:%d: %s: %v\n", fname, failure.Envelope.Line, failure.Envelope.Column, failure.Envelope.Type, failure.Err)
+			}
+
+			err = fmt.Errorf("%d envelope(s) could not be decrypted", len(failures))
+			if replacesInput {
+				err = fmt.Errorf("%v, %s was left unchanged", err, fname)
+			}
+		}
+
+		if err != nil {
 			panic(fmt.Errorf("unable to decrypt the provided text: %v", err))
 		}
 	},
@@ -56,9 +117,18 @@ func init() {
 	rootCmd.AddCommand(decryptCmd)
 
 	decryptCmd.Flags().StringP("input", "i", "", "An optional input file to parse")
+	addOutputFlags(decryptCmd)
+	decryptCmd.Flags().StringSlice("only", []string{}, "Only decrypt envelopes of these types (KMS, SECMAN), others are left untouched")
+	decryptCmd.Flags().StringSlice("skip", []string{}, "Leave envelopes of these types (KMS, SECMAN) untouched, for example SECMAN references resolved at runtime")
+	decryptCmd.Flags().String("on-error", string(cryptography.FailFast), "What to do with envelopes that cannot be decrypted: fail stops at the first one, keep leaves them as they are and mark replaces them with --error-marker. keep and mark report every failure and fail once the output is written")
+	decryptCmd.Flags().String("error-marker", cryptography.DefaultFailureMarker, "Replaces envelopes that cannot be decrypted when --on-error is mark")
+	decryptCmd.Flags().String("on-expired", string(cryptography.WarnExpired), "What to do with envelopes whose recorded expiry has passed: allow, warn or refuse. refuse treats them as envelopes that cannot be decrypted")
+	decryptCmd.Flags().String("escape", "none", "Escape decrypted values for their surroundings: json, yaml, toml, dotenv, shell, auto or none. auto detects it from the input file name, none writes values as they are")
 }
 
-func processDecrypt(in io.Reader, out io.Writer, strategy cryptography.Decryptor) error {
+// processDecrypt decrypts every envelope of the input. When decryption carries on after failures, the output is
+// still written and the failures are returned as cryptography.DecryptErrors.
+func processDecrypt(in io.Reader, out io.Writer, strategy cryptography.Decryptor, escaper formats.Escaper, options cryptography.DecryptOptions) error {
 	var (
 		payload []byte
 		err     error
@@ -69,11 +139,16 @@ func processDecrypt(in io.Reader, out io.Writer, strategy cryptography.Decryptor
 		return fmt.Errorf("unable to read input: %v", err)
 	}
 
-	if result, err = cryptography.DecryptEnvelopes(string(payload), strategy); err != nil {
+	options.Render = func(input string, envelope cryptography.Envelope, plaintext string) (string, error) {
+		return escaper(input, envelope.Start, envelope.End, plaintext)
+	}
+
+	result, err = cryptography.DecryptEnvelopesWithOptions(string(payload), strategy, options)
+	if _, partial := err.(cryptography.DecryptErrors); err != nil && !partial {
 		return fmt.Errorf("unable to decrypt input: %v", err)
 	}
 
 	out.Write([]byte(result))
 
-	return nil
+	return err
 }
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// editCmd represents the edit command
+var editCmd = &cobra.Command{
+	Use:   "edit file",
+	Short: "Edit a file containing KMS envelopes in your $EDITOR",
+	Long: `Decrypt every ENC[KMS,...] value of a file into a temporary copy and open it in $EDITOR.
+
+Each decrypted value is shown between DEC[n:token] and [/DEC:token] markers, where the
+token is picked at random for every session. Leave the markers in place and change
+the text between them. Once the editor exits, only the values
+that changed are encrypted again, with the same KMS key they were originally
+encrypted with, and the file is replaced atomically. Changed values keep the expiry
+and note of their envelope, with a new creation time and creator. Removing a value
+together with its markers removes it from the file.
+
+The temporary copy is created with 0600 permissions on a memory backed file system
+(/dev/shm) when one is available, and is overwritten before it is deleted. Only that
+file is overwritten: editors that save by writing a new file and renaming it, or that
+keep swap, backup or undo files, can leave decrypted copies behind, so turn those off
+(for example with vim -n). ENC[SECMAN,...] references are left as they are.
+
+Example:
+
+EDITOR=vim dragoman edit config/prod.yaml`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		awsRegion, err := cmd.Flags().GetString("aws-region")
+		if err != nil {
+			panic(err)
+		}
+
+		// Changed values are re-encrypted now, by whoever runs the edit
+		metadata := &cryptography.EnvelopeMetadata{Created: time.Now().UTC().Truncate(time.Second)}
+		if awsRegion != "" {
+			metadata.Creator = lookupCreator(awsRegion)
+		}
+
+		if err = processEdit(&editConfig{
+			File:      args[0],
+			Editor:    getFirstEnv("EDITOR", "VISUAL"),
+			Log:       os.Stderr,
+			AwsRegion: awsRegion,
+			Policy:    decryptionPolicy,
+			Metadata:  metadata,
+		}); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(editCmd)
+
+	editCmd.Flags().String("aws-region", getFirstEnv("AWS_REGION", "AWS_DEFAULT_REGION"), "Provides the AWS region to use for KMS")
+}
+
+type editConfig struct {
+	File      string
+	Editor    string
+	Log       io.Writer
+	AwsRegion string
+	Policy    *cryptography.Policy           // Envelopes outside it are not opened
+	Metadata  *cryptography.EnvelopeMetadata // Creation time and creator of the values that changed
+}
+
+// editedValue keeps track of a decrypted value so it can be compared after editing
+type editedValue struct {
+	Envelope  cryptography.Envelope
+	KeyArn    string
+	Plaintext string
+	Metadata  *cryptography.EnvelopeMetadata // Carried over to the new envelope when the value changes
+}
+
+func processEdit(cfg *editConfig) error {
+	info, err := os.Stat(cfg.File)
+	if err != nil {
+		return fmt.Errorf("unable to open file \"%s\": %v", cfg.File, err)
+	}
+
+	var contents []byte
+	if contents, err = os.ReadFile(cfg.File); err != nil {
+		return fmt.Errorf("unable to read file \"%s\": %v", cfg.File, err)
+	}
+
+	var strategy *cryptography.KmsCryptoStrategy
+	if strategy, err = newKmsStrategy(cfg.AwsRegion); err != nil {
+		return fmt.Errorf("unable to create kms crypto strategy: %v", err)
+	}
+
+	var markers *editMarkers
+	if markers, err = newEditMarkers(); err != nil {
+		return err
+	}
+
+	if strings.Contains(string(contents), markers.token) {
+		return fmt.Errorf("\"%s\" happens to contain the edit marker token, try again", cfg.File)
+	}
+
+	// Swap every KMS envelope for its plaintext surrounded by markers
+	values := []editedValue{}
+	decrypted, err := cryptography.ReplaceEnvelopes(string(contents), func(envelope cryptography.Envelope) (string, error) {
+		if envelope.Type != cryptography.CRYPTO_KEY_KMS {
+			return envelope.Raw, nil
+		}
+
+		if err := cfg.Policy.CheckEnvelope(envelope.Value()); err != nil {
+			return "", fmt.Errorf("%s:%d:%d: %v", cfg.File, envelope.Line, envelope.Column, err)
+		}
+
+		plaintext, keyArn, err := strategy.WithPolicy(cfg.Policy).DecryptWithKeyId(envelope.Value())
+		if err != nil {
+			return "", fmt.Errorf("%s:%d:%d: %v", cfg.File, envelope.Line, envelope.Column, err)
+		}
+
+		if strings.Contains(string(plaintext), markers.token) {
+			return "", fmt.Errorf("%s:%d:%d: the decrypted value happens to contain the edit marker token, try again", cfg.File, envelope.Line, envelope.Column)
+		}
+
+		values = append(values, editedValue{
+			Envelope:  envelope,
+			KeyArn:    keyArn,
+			Plaintext: string(plaintext),
+			Metadata:  envelopeMetadata(envelope),
+		})
+
+		return markers.start(len(values)) + string(plaintext) + markers.end, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var edited []byte
+	if edited, err = editInTempFile(cfg, []byte(decrypted)); err != nil {
+		return err
+	}
+
+	if bytes.Equal(edited, []byte(decrypted)) {
+		fmt.Fprintf(cfg.Log, "%s: no changes\n", cfg.File)
+		return nil
+	}
+
+	var output string
+	var changed int
+	if output, changed, err = reencryptEdited(string(edited), values, markers, strategy, cfg.Metadata); err != nil {
+		return fmt.Errorf("%s was left unchanged: %v", cfg.File, err)
+	}
+
+	if err = writeFileAtomic(cfg.File, []byte(output), info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cfg.Log, "%s: re-encrypted %d value(s)\n", cfg.File, changed)
+
+	return nil
+}
+
+// editInTempFile writes the plaintext to a private temporary file, opens it in the editor and returns the result.
+// The temporary file is shredded whatever happens.
+func editInTempFile(cfg *editConfig, plaintext []byte) ([]byte, error) {
+	dir := os.TempDir()
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		dir = "/dev/shm"
+	} else {
+		fmt.Fprintf(cfg.Log, "warning: no memory backed file system found, the decrypted copy is written to %s\n", dir)
+	}
+
+	// Keep the extension so editors pick the right syntax highlighting
+	tmp, err := os.CreateTemp(dir, "dragoman-*"+filepath.Ext(cfg.File))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary file: %v", err)
+	}
+	defer shredFile(tmp.Name())
+
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("unable to set permissions on \"%s\": %v", tmp.Name(), err)
+	}
+
+	_, err = tmp.Write(plaintext)
+	tmp.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to write \"%s\": %v", tmp.Name(), err)
+	}
+
+	editor := strings.Fields(cfg.Editor)
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	editorCmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+
+	if err = editorCmd.Run(); err != nil {
+		return nil, fmt.Errorf("the editor exited with an error, nothing was changed: %v", err)
+	}
+
+	var edited []byte
+	if edited, err = os.ReadFile(tmp.Name()); err != nil {
+		return nil, fmt.Errorf("unable to read \"%s\": %v", tmp.Name(), err)
+	}
+
+	return edited, nil
+}
+
+// editMarkers surround the decrypted values of an edit session. They carry a random token, so text that merely
+// looks like a marker, in a value or anywhere else in the file, is never mistaken for one.
+type editMarkers struct {
+	token      string
+	startRegex *regexp.Regexp
+	end        string
+}
+
+func newEditMarkers() (*editMarkers, error) {
+	random := make([]byte, 6)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, fmt.Errorf("unable to generate the edit markers: %v", err)
+	}
+
+	token := hex.EncodeToString(random)
+
+	return &editMarkers{
+		token:      token,
+		startRegex: regexp.MustCompile(`DEC\[(\d+):` + token + `\]`),
+		end:        "[/DEC:" + token + "]",
+	}, nil
+}
+
+// start returns the marker opening the value with the given index
+func (m *editMarkers) start(index int) string {
+	return fmt.Sprintf("DEC[%d:%s]", index, m.token)
+}
+
+// reencryptEdited swaps the markers back for envelopes, only encrypting values that changed
+func reencryptEdited(edited string, values []editedValue, markers *editMarkers, strategy *cryptography.KmsCryptoStrategy, metadata *cryptography.EnvelopeMetadata) (string, int, error) {
+	var sb strings.Builder
+	seen := map[int]bool{}
+	changed := 0
+	last := 0
+
+	for {
+		loc := markers.startRegex.FindStringSubmatchIndex(edited[last:])
+		if loc == nil {
+			break
+		}
+
+		start := last + loc[0]
+		index, _ := strconv.Atoi(edited[last+loc[2] : last+loc[3]])
+		if index < 1 || index > len(values) {
+			return "", 0, fmt.Errorf("unknown marker DEC[%d]", index)
+		}
+
+		if seen[index] {
+			return "", 0, fmt.Errorf("marker DEC[%d] appears more than once", index)
+		}
+		seen[index] = true
+
+		valueStart := last + loc[1]
+		valueEnd := strings.Index(edited[valueStart:], markers.end)
+		if valueEnd < 0 {
+			return "", 0, fmt.Errorf("marker DEC[%d] is missing its closing %s", index, markers.end)
+		}
+		valueEnd += valueStart
+
+		original := values[index-1]
+		replacement := original.Envelope.Raw
+
+		if value := edited[valueStart:valueEnd]; value != original.Plaintext {
+			envelope, err := strategy.WithMetadata(replacedMetadata(original.Metadata, metadata)).Encrypt([]byte(value), original.KeyArn)
+			if err != nil {
+				return "", 0, fmt.Errorf("error encountered attempting KMS encryption of DEC[%d]: %v", index, err)
+			}
+
+			replacement = cryptography.RewrapEnvelope(original.Envelope.Raw, envelope)
+			changed++
+		}
+
+		sb.WriteString(edited[last:start])
+		sb.WriteString(replacement)
+		last = valueEnd + len(markers.end)
+	}
+
+	sb.WriteString(edited[last:])
+
+	return sb.String(), changed, nil
+}
+
+// shredFile overwrites a file with random data before removing it
+func shredFile(name string) {
+	if info, err := os.Stat(name); err == nil {
+		if file, err := os.OpenFile(name, os.O_WRONLY, 0); err == nil {
+			io.CopyN(file, rand.Reader, info.Size())
+			file.Sync()
+			file.Close()
+		}
+	}
+
+	os.Remove(name)
+}
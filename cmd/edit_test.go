@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReencryptEdited(t *testing.T) {
+	t.Run("it should not mistake text that looks like a marker for one", func(t *testing.T) {
+		markers, err := newEditMarkers()
+		assert.Nil(t, err)
+
+		values := []editedValue{
+			{Envelope: cryptography.Envelope{Raw: "ENC[KMS,first]"}, Plaintext: "a [/DEC] in the value"},
+			{Envelope: cryptography.Envelope{Raw: "ENC[KMS,second]"}, Plaintext: "DEC[1] again"},
+		}
+		edited := "# DEC[2] in a comment\n" +
+			"a: " + markers.start(1) + values[0].Plaintext + markers.end + "\n" +
+			"b: " + markers.start(2) + values[1].Plaintext + markers.end + "\n"
+
+		output, changed, err := reencryptEdited(edited, values, markers, nil, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, changed)
+		assert.Equal(t, "# DEC[2] in a comment\na: ENC[KMS,first]\nb: ENC[KMS,second]\n", output)
+	})
+
+	t.Run("it should keep the metadata of changed values and record who changed them", func(t *testing.T) {
+		markers, _ := newEditMarkers()
+		client := new(kmsClientMock).withKey(testKeyArn, testKeyArn)
+
+		expires := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+		original := &cryptography.EnvelopeMetadata{
+			Created:  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			Creator:  "arn:aws:iam::123456789012:user/alice",
+			Expires:  &expires,
+			KeyAlias: "alias/app",
+			Note:     "rotated quarterly",
+		}
+		session := &cryptography.EnvelopeMetadata{
+			Created: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC),
+			Creator: "arn:aws:iam::123456789012:user/bob",
+		}
+
+		values := []editedValue{
+			{Envelope: cryptography.Envelope{Raw: "ENC[KMS,first]"}, KeyArn: testKeyArn, Plaintext: "old", Metadata: original},
+			{Envelope: cryptography.Envelope{Raw: "ENC[KMS,second]"}, KeyArn: testKeyArn, Plaintext: "legacy"},
+		}
+		edited := "a: " + markers.start(1) + "new" + markers.end + "\n" +
+			"b: " + markers.start(2) + "also new" + markers.end + "\n"
+
+		output, changed, err := reencryptEdited(edited, values, markers, cryptography.NewKmsCryptoStrategyWithClient(client), session)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, changed)
+
+		envelopes := cryptography.FindEnvelopes(output)
+		assert.Len(t, envelopes, 2)
+
+		first, err := cryptography.InspectEnvelope(envelopes[0].Value())
+		assert.Nil(t, err)
+		assert.Equal(t, &cryptography.EnvelopeMetadata{
+			Created:  session.Created,
+			Creator:  session.Creator,
+			Expires:  &expires,
+			KeyAlias: "alias/app",
+			Note:     "rotated quarterly",
+		}, first.Metadata)
+
+		second, err := cryptography.InspectEnvelope(envelopes[1].Value())
+		assert.Nil(t, err)
+		assert.Equal(t, session, second.Metadata, "values without metadata get the metadata of the session")
+	})
+
+	t.Run("it should pick a new token for every session", func(t *testing.T) {
+		first, _ := newEditMarkers()
+		second, _ := newEditMarkers()
+
+		assert.NotEqual(t, first.token, second.token)
+	})
+}
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"fmt"
 	"io"
 	"os"
 
+	"github.com/meltwater/dragoman/cryptography"
 	"github.com/spf13/cobra"
 )
 
@@ -19,61 +21,202 @@ Encrypt with AWS KMS
 "My string to encrypt" | dragoman encrypt --kms-key-id myKmsKey
 
 Encrypt with AWS Secrets Manager
-dragoman encrypt --sm-key-id mySecretsManagerKey --sm-secret-key myValuesKey`,
+dragoman encrypt --sm-key-id mySecretsManagerKey --sm-secret-key myValuesKey
+
+Encrypt with AWS Secrets Manager, pinned to the previous version of the secret
+dragoman encrypt --sm-key-id mySecretsManagerKey --sm-version-stage AWSPREVIOUS
+
+Encrypt with AWS Secrets Manager, reading a different secret per environment when decrypting
+dragoman encrypt --sm-key-id '${DRAGOMAN_ENV}/db' --sm-secret-key password --var DRAGOMAN_ENV=dev
+
+Encrypt selected values of a YAML, JSON, TOML or dotenv file with AWS KMS
+dragoman encrypt --kms-key-id myKmsKey --file values.yaml --keys 'db.password,api.*'
+
+Encrypt selected values of a file in place, keeping a backup of the original
+dragoman encrypt --kms-key-id myKmsKey --file values.yaml --keys 'db.password' --in-place --backup .orig
+
+Encrypt with the key of the first matching creation rule of .dragoman.yaml
+dragoman encrypt --file envs/prod/values.yaml --keys 'db.password' --in-place
+
+Encrypt a JSON map of names to values with AWS KMS and write a dotenv file
+dragoman encrypt --kms-key-id myKmsKey --batch json --batch-output dotenv < secrets.json > .env
+
+Encrypt with AWS KMS, recording that the secret has to be rotated within 90 days
+dragoman encrypt --kms-key-id myKmsKey --expires 90d --note "rotated by the platform team"
+
+Regenerate an encrypted dotenv file, keeping the envelopes of the values that did not change
+dragoman encrypt --kms-key-id myKmsKey --batch json --batch-output dotenv --deterministic -o .env < secrets.json`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// Standard out, --output or --in-place (which replaces --file), only written once encryption is done
+		file, _ := cmd.Flags().GetString("file")
+		output, err := newCommandOutput(cmd, file, false)
+		if err != nil {
+			panic(err)
+		}
+
+		// The KMS key comes from --kms-key-id, the creation rules of .dragoman.yaml or $KMS_KEY_ID, in that order
+		var kmsKey, kmsRegion string
+		if smKey, _ := cmd.Flags().GetString("sm-key-id"); smKey == "" {
+			config, err := loadProjectConfig()
+			if err != nil {
+				panic(err)
+			}
+
+			if kmsKey, kmsRegion, err = resolveKmsKey(cmd, "kms-key-id", config, file); err != nil {
+				panic(err)
+			}
+		}
+
 		// KMS Envelope Encrpytion
-		var kmsKey string
-		if kmsKey, _ = cmd.Flags().GetString("kms-key-id"); kmsKey != "" {
-			var (
-				awsRegion string
-				wrapLines bool
-				err       error
-			)
+		if kmsKey != "" {
+			var wrapLines bool
 			// Get any other relevant flags or environment variables
-			if awsRegion, err = cmd.Flags().GetString("aws-region"); err != nil {
+			awsRegion := kmsRegion
+
+			if wrapLines, err = cmd.Flags().GetBool("wrap"); err != nil {
 				panic(err)
 			}
 
-			if wrapLines, err = cmd.Flags().GetBool("wrap"); err != nil {
+			// Deterministic envelopes reuse the data key of the envelopes in the file being replaced
+			deterministic, _ := cmd.Flags().GetBool("deterministic")
+			var previous []string
+			if deterministic {
+				if previous, err = previousEnvelopes(file, output.path); err != nil {
+					panic(err)
+				}
+			}
+
+			metadata, err := newEnvelopeMetadata(cmd, awsRegion)
+			if err != nil {
 				panic(err)
 			}
 
+			// Structured file encryption
+			if file != "" {
+				keys, _ := cmd.Flags().GetStringSlice("keys")
+				keysRegex, _ := cmd.Flags().GetString("keys-regex")
+				format, _ := cmd.Flags().GetString("format")
+
+				if err = processFileEncrypt(&encryptConfig{
+					Out:           output,
+					Key:           kmsKey,
+					AwsRegion:     awsRegion,
+					File:          file,
+					Format:        format,
+					Keys:          keys,
+					KeysRegex:     keysRegex,
+					Metadata:      metadata,
+					Deterministic: deterministic,
+					Previous:      previous,
+				}); err != nil {
+					panic(err)
+				}
+
+				if err = output.Commit(); err != nil {
+					panic(err)
+				}
+
+				return
+			}
+
+			// Batch encryption
+			if batch, _ := cmd.Flags().GetString("batch"); batch != "" {
+				batchOutput, _ := cmd.Flags().GetString("batch-output")
+
+				if err = processBatchEncrypt(&encryptConfig{
+					In:            os.Stdin,
+					Out:           output,
+					Key:           kmsKey,
+					AwsRegion:     awsRegion,
+					Batch:         batch,
+					BatchOutput:   batchOutput,
+					Metadata:      metadata,
+					Deterministic: deterministic,
+					Previous:      previous,
+				}); err != nil {
+					panic(err)
+				}
+
+				if err = output.Commit(); err != nil {
+					panic(err)
+				}
+
+				return
+			}
+
 			// Try and do the encryption
 			if err = processKmsEncrypt(&encryptConfig{
-				In:        os.Stdin,
-				Out:       os.Stdout,
-				Key:       kmsKey,
-				AwsRegion: awsRegion,
-				WrapLines: wrapLines,
+				In:            os.Stdin,
+				Out:           output,
+				Key:           kmsKey,
+				AwsRegion:     awsRegion,
+				WrapLines:     wrapLines,
+				Metadata:      metadata,
+				Deterministic: deterministic,
+				Previous:      previous,
 			}); err != nil {
 				panic(err)
 			}
 
+			if err = output.Commit(); err != nil {
+				panic(err)
+			}
+
 			return
 		}
 
 		// Secrets Manager
 		var smKey string
 		if smKey, _ = cmd.Flags().GetString("sm-key-id"); smKey != "" {
-			var (
-				awsRegion string
-				err       error
-			)
+			var awsRegion string
 
 			if awsRegion, err = cmd.Flags().GetString("aws-region"); err != nil {
 				panic(err)
 			}
 
+			if file != "" {
+				panic(fmt.Errorf("encrypting values of a file is only supported with --kms-key-id"))
+			}
+
+			if batch, _ := cmd.Flags().GetString("batch"); batch != "" {
+				panic(fmt.Errorf("batch encryption is only supported with --kms-key-id"))
+			}
+
+			if deterministic, _ := cmd.Flags().GetBool("deterministic"); deterministic {
+				panic(fmt.Errorf("--deterministic is only supported with --kms-key-id"))
+			}
+
+			metadata, err := newEnvelopeMetadata(cmd, awsRegion)
+			if err != nil {
+				panic(err)
+			}
+
+			// Validate the reference by default, unless there are no credentials to do it with
+			validate, _ := cmd.Flags().GetBool("validate")
+			if !cmd.Flags().Changed("validate") {
+				validate = cryptography.AwsCredentialsAvailable(awsRegion)
+			}
+
 			var smSecretKey, _ = cmd.Flags().GetString("sm-secret-key")
+			var smVersionId, _ = cmd.Flags().GetString("sm-version-id")
+			var smVersionStage, _ = cmd.Flags().GetString("sm-version-stage")
 			if err = processSMEncrypt(&encryptConfig{
-				Out:       os.Stdout,
-				Key:       smKey,
-				SecretKey: smSecretKey,
-				AwsRegion: awsRegion,
+				Out:           output,
+				Key:           smKey,
+				SecretKey:     smSecretKey,
+				SecretVersion: cryptography.SecretVersion{Id: smVersionId, Stage: smVersionStage},
+				AwsRegion:     awsRegion,
+				Validate:      validate,
+				Variables:     referenceVariables,
+				Metadata:      metadata,
 			}); err != nil {
 				panic(err)
 			}
 
+			if err = output.Commit(); err != nil {
+				panic(err)
+			}
+
 			return
 		}
 
@@ -87,16 +230,41 @@ func init() {
 	// Setup Flags(this command only) and Persistent Flags (this command and sub commands)
 	encryptCmd.Flags().String("kms-key-id", os.Getenv("KMS_KEY_ID"), "Provides the KMS Key ID")
 	encryptCmd.Flags().String("sm-key-id", "", "Provides the Secrets Manager key to use")
-	encryptCmd.Flags().String("sm-secret-key", "", "Provides the Key for Key/Value pairs in Secrets Manager")
+	encryptCmd.Flags().String("sm-secret-key", "", "Provides the Key for Key/Value pairs in Secrets Manager, nested values can be selected with a path like 'db.hosts[0]'")
+	encryptCmd.Flags().String("sm-version-id", "", "Pins the Secrets Manager reference to a version id")
+	encryptCmd.Flags().String("sm-version-stage", "", "Pins the Secrets Manager reference to a version stage, for example AWSPREVIOUS. AWSCURRENT is read by default")
+	encryptCmd.Flags().Bool("validate", false, "Check that the Secrets Manager secret (or its pinned version) exists and holds --sm-secret-key. On by default when AWS credentials are available")
 	encryptCmd.Flags().String("aws-region", getFirstEnv("AWS_REGION", "AWS_DEFAULT_REGION"), "Provides the AWS region to use for KMS")
 	encryptCmd.Flags().BoolP("wrap", "w", false, "Wrap long lines at 64 characters")
+	encryptCmd.Flags().String("file", "", "A YAML, JSON, TOML or dotenv file whose selected values should be encrypted")
+	encryptCmd.Flags().StringSlice("keys", nil, "Comma separated dotted paths of the values to encrypt in --file, globs like 'api.*' are supported")
+	encryptCmd.Flags().String("keys-regex", "", "A regular expression matched against the dotted paths of the values to encrypt in --file")
+	encryptCmd.Flags().String("format", "", "The format of --file (yaml, json, toml or dotenv), detected from the file name by default")
+	addOutputFlags(encryptCmd)
+	addEnvironmentFlag(encryptCmd)
+	addMetadataFlags(encryptCmd)
+	encryptCmd.Flags().String("batch", "", "Encrypt many values read from standard in: lines (one value per line), csv (name,value rows) or json (an object of names to values)")
+	encryptCmd.Flags().String("batch-output", "", "The output format of --batch: lines, csv, json, dotenv or yaml. Defaults to the --batch format")
+	encryptCmd.Flags().Bool("deterministic", false, "Give equal values in the same place equal envelopes, reusing the data key of the file being replaced. Shows which values did not change")
 }
 
 type encryptConfig struct {
-	In        io.Reader
-	Out       io.Writer
-	Key       string
-	SecretKey string // Secrets Manager specific
-	AwsRegion string
-	WrapLines bool
+	In            io.Reader
+	Out           io.Writer
+	Key           string
+	SecretKey     string                           // Secrets Manager specific
+	SecretVersion cryptography.SecretVersion       // Secrets Manager specific
+	Validate      bool                             // Secrets Manager specific
+	Variables     *cryptography.ReferenceVariables // Secrets Manager specific
+	AwsRegion     string
+	WrapLines     bool
+	Metadata      *cryptography.EnvelopeMetadata // Recorded in the new envelopes
+	File          string                         // Structured file encryption specific
+	Format        string                         // Structured file encryption specific
+	Keys          []string                       // Structured file encryption specific
+	KeysRegex     string                         // Structured file encryption specific
+	Batch         string                         // Batch encryption specific
+	BatchOutput   string                         // Batch encryption specific
+	Deterministic bool                           // KMS specific, nonces are derived from the values
+	Previous      []string                       // KMS specific, envelopes whose data key deterministic encryption reuses
 }
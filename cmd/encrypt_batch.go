@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/meltwater/dragoman/formats"
+)
+
+// batchEntry is a single value of a batch, names are empty for line delimited input
+type batchEntry struct {
+	Name  string
+	Value string
+}
+
+// processBatchEncrypt encrypts every value of a batch with one KMS strategy that reuses its data key
+func processBatchEncrypt(cfg *encryptConfig) error {
+	var err error
+
+	if cfg.AwsRegion == "" {
+		return fmt.Errorf("an aws region must be provided for KMS encryption")
+	}
+
+	outputFormat := cfg.BatchOutput
+	if outputFormat == "" {
+		outputFormat = cfg.Batch
+	}
+
+	var entries []batchEntry
+	if entries, err = readBatch(cfg.In, cfg.Batch); err != nil {
+		return err
+	}
+
+	// Check the output can be written before making any KMS calls
+	switch outputFormat {
+	case "lines", "csv", "json", "yaml":
+	case "dotenv":
+		for _, entry := range entries {
+			if !envNameRegex.MatchString(entry.Name) {
+				return fmt.Errorf("\"%s\" is not a valid variable name", entry.Name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown batch output format \"%s\", expected lines, csv, json, dotenv or yaml", outputFormat)
+	}
+
+	if cfg.Batch == "lines" && outputFormat != "lines" {
+		return fmt.Errorf("line delimited values have no names, they can only be written as lines")
+	}
+
+	var strategy *cryptography.KmsCryptoStrategy
+	if strategy, err = newKmsEncryptStrategy(cfg, true); err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		// Lines have no name, so their index keeps equal values on different lines apart
+		context := entry.Name
+		if cfg.Batch == "lines" {
+			context = strconv.Itoa(i)
+		}
+
+		if entries[i].Value, err = strategy.EncryptWithContext([]byte(entry.Value), cfg.Key, context); err != nil {
+			return fmt.Errorf("error encountered attempting KMS encryption: %v", err)
+		}
+	}
+
+	return writeBatch(cfg.Out, entries, outputFormat)
+}
+
+// readBatch reads line delimited values, or a map of names to values as CSV (name,value rows) or a JSON object
+func readBatch(in io.Reader, format string) ([]batchEntry, error) {
+	entries := []batchEntry{}
+
+	switch format {
+	case "lines":
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			if line := strings.TrimSuffix(scanner.Text(), "\r"); line != "" {
+				entries = append(entries, batchEntry{Value: line})
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("unable to read input: %v", err)
+		}
+
+		return entries, nil
+	case "csv":
+		reader := csv.NewReader(in)
+		reader.FieldsPerRecord = 2
+
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the csv input, expected name,value rows: %v", err)
+		}
+
+		for _, record := range records {
+			entries = append(entries, batchEntry{Name: record[0], Value: record[1]})
+		}
+	case "json":
+		// Decode token by token to keep the order of the input
+		decoder := json.NewDecoder(in)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			return nil, fmt.Errorf("the json input must be an object of names to string values")
+		}
+
+		for decoder.More() {
+			var entry batchEntry
+
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the json input: %v", err)
+			}
+			entry.Name = token.(string)
+
+			if err = decoder.Decode(&entry.Value); err != nil {
+				return nil, fmt.Errorf("the value of \"%s\" must be a string: %v", entry.Name, err)
+			}
+
+			entries = append(entries, entry)
+		}
+
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("unable to read the json input: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown batch format \"%s\", expected lines, csv or json", format)
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if seen[entry.Name] {
+			return nil, fmt.Errorf("\"%s\" is listed more than once", entry.Name)
+		}
+		seen[entry.Name] = true
+	}
+
+	return entries, nil
+}
+
+// writeBatch writes the encrypted entries in the requested format, in the order they were read
+func writeBatch(out io.Writer, entries []batchEntry, format string) error {
+	buff := &bytes.Buffer{}
+
+	switch format {
+	case "lines":
+		for _, entry := range entries {
+			fmt.Fprintln(buff, entry.Value)
+		}
+	case "csv":
+		writer := csv.NewWriter(buff)
+		for _, entry := range entries {
+			writer.Write([]string{entry.Name, entry.Value})
+		}
+		writer.Flush()
+	case "json":
+		buff.WriteString("{")
+		for i, entry := range entries {
+			if i > 0 {
+				buff.WriteString(",")
+			}
+			fmt.Fprintf(buff, "\n  %s: %s", formats.QuoteJSON(entry.Name), formats.QuoteJSON(entry.Value))
+		}
+		buff.WriteString("\n}\n")
+	case "dotenv":
+		for _, entry := range entries {
+			fmt.Fprintf(buff, "%s=%s\n", entry.Name, formats.RenderValue(formats.Dotenv, entry.Value))
+		}
+	case "yaml":
+		for _, entry := range entries {
+			fmt.Fprintf(buff, "%s: %s\n", formats.RenderValue(formats.YAML, entry.Name), formats.RenderValue(formats.YAML, entry.Value))
+		}
+	default:
+		return fmt.Errorf("unknown batch output format \"%s\", expected lines, csv, json, dotenv or yaml", format)
+	}
+
+	_, err := out.Write(buff.Bytes())
+
+	return err
+}
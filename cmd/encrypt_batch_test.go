@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessBatchEncrypt(t *testing.T) {
+	t.Run("it should keep equal lines apart in deterministic mode", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("us-east-1").withKey(testKeyArn, testKeyArn)
+
+		encrypt := func() []string {
+			out := &bytes.Buffer{}
+			err := processBatchEncrypt(&encryptConfig{
+				In:            strings.NewReader("same\nother\nsame\n"),
+				Out:           out,
+				Key:           testKeyArn,
+				AwsRegion:     "us-east-1",
+				Batch:         "lines",
+				Deterministic: true,
+				Previous:      []string{},
+			})
+			assert.Nil(t, err)
+
+			return strings.Split(strings.TrimSpace(out.String()), "\n")
+		}
+
+		first := encrypt()
+		assert.Len(t, first, 3)
+		assert.NotEqual(t, first[0], first[2])
+
+		// The same line of the same batch gets the same envelope again
+		assert.Equal(t, first, encrypt())
+	})
+}
+
+func TestReadBatch(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		format   string
+		input    string
+		expected []batchEntry
+		err      string
+	}{
+		{
+			name:     "lines skip blank lines and carriage returns",
+			format:   "lines",
+			input:    "first\r\n\nsecond\n",
+			expected: []batchEntry{{Value: "first"}, {Value: "second"}},
+		},
+		{
+			name:     "lines may repeat, as they have no names",
+			format:   "lines",
+			input:    "same\nsame\n",
+			expected: []batchEntry{{Value: "same"}, {Value: "same"}},
+		},
+		{
+			name:     "csv rows are names and values",
+			format:   "csv",
+			input:    "db,\"a,b\"\napi,key\n",
+			expected: []batchEntry{{Name: "db", Value: "a,b"}, {Name: "api", Value: "key"}},
+		},
+		{
+			name:   "csv rows need two fields",
+			format: "csv",
+			input:  "db,a\napi\n",
+			err:    "unable to read the csv input, expected name,value rows: record on line 2: wrong number of fields",
+		},
+		{
+			name:   "csv names must be unique",
+			format: "csv",
+			input:  "db,a\ndb,b\n",
+			err:    "\"db\" is listed more than once",
+		},
+		{
+			name:     "json objects keep their order",
+			format:   "json",
+			input:    `{"z": "last", "a": "first"}`,
+			expected: []batchEntry{{Name: "z", Value: "last"}, {Name: "a", Value: "first"}},
+		},
+		{
+			name:   "json values must be strings",
+			format: "json",
+			input:  `{"port": 5432}`,
+			err:    "the value of \"port\" must be a string: json: cannot unmarshal number into Go value of type string",
+		},
+		{
+			name:   "json input must be an object",
+			format: "json",
+			input:  `["a"]`,
+			err:    "the json input must be an object of names to string values",
+		},
+		{
+			name:   "json names must be unique",
+			format: "json",
+			input:  `{"db": "a", "db": "b"}`,
+			err:    "\"db\" is listed more than once",
+		},
+		{
+			name:   "unknown formats are refused",
+			format: "xml",
+			err:    "unknown batch format \"xml\", expected lines, csv or json",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := readBatch(strings.NewReader(test.input), test.format)
+
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, entries)
+		})
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	entries := []batchEntry{{Name: "db", Value: "ENC[KMS,a=]"}, {Name: "api.key", Value: "ENC[KMS,b=]"}}
+
+	for _, test := range []struct {
+		format   string
+		expected string
+		err      string
+	}{
+		{format: "lines", expected: "ENC[KMS,a=]\nENC[KMS,b=]\n"},
+		{format: "csv", expected: "db,\"ENC[KMS,a=]\"\napi.key,\"ENC[KMS,b=]\"\n"},
+		{format: "json", expected: "{\n  \"db\": \"ENC[KMS,a=]\",\n  \"api.key\": \"ENC[KMS,b=]\"\n}\n"},
+		{format: "dotenv", expected: "db=ENC[KMS,a=]\napi.key=ENC[KMS,b=]\n"},
+		{format: "yaml", expected: "db: ENC[KMS,a=]\napi.key: ENC[KMS,b=]\n"},
+		{format: "xml", err: "unknown batch output format \"xml\", expected lines, csv, json, dotenv or yaml"},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := writeBatch(out, entries, test.format)
+
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				assert.Empty(t, out.String())
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, out.String())
+		})
+	}
+}
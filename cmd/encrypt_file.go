@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/meltwater/dragoman/formats"
+)
+
+func processFileEncrypt(cfg *encryptConfig) error {
+	var err error
+
+	if cfg.AwsRegion == "" {
+		return fmt.Errorf("an aws region must be provided for KMS encryption")
+	}
+
+	var selector formats.Selector
+	switch {
+	case cfg.KeysRegex != "" && len(cfg.Keys) > 0:
+		return fmt.Errorf("only one of --keys and --keys-regex can be provided")
+	case cfg.KeysRegex != "":
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(cfg.KeysRegex); err != nil {
+			return fmt.Errorf("invalid --keys-regex: %v", err)
+		}
+		selector = formats.RegexSelector(re)
+	case len(cfg.Keys) > 0:
+		selector = formats.GlobSelector(cfg.Keys)
+	default:
+		return fmt.Errorf("the values to encrypt must be selected with --keys or --keys-regex")
+	}
+
+	var format formats.Format
+	if cfg.Format != "" {
+		format, err = formats.ParseFormat(cfg.Format)
+	} else {
+		format, err = formats.DetectFormat(cfg.File)
+	}
+	if err != nil {
+		return err
+	}
+
+	var contents []byte
+	if contents, err = os.ReadFile(cfg.File); err != nil {
+		return fmt.Errorf("unable to read file \"%s\": %v", cfg.File, err)
+	}
+
+	var values []formats.Value
+	if values, err = formats.FindValues(format, string(contents)); err != nil {
+		return fmt.Errorf("unable to read \"%s\": %v", cfg.File, err)
+	}
+
+	var strategy *cryptography.KmsCryptoStrategy
+	if strategy, err = newKmsEncryptStrategy(cfg, false); err != nil {
+		return err
+	}
+
+	matched := 0
+	output, err := formats.ReplaceValues(string(contents), values, func(value formats.Value) (string, bool, error) {
+		// Values that are already encrypted are left alone so the command can be run repeatedly
+		if !selector(value.Path) || cryptography.ExtractEncryptionType(value.Text) != "" {
+			return "", false, nil
+		}
+
+		matched++
+
+		envelope, err := strategy.EncryptWithContext([]byte(value.Text), cfg.Key, value.Key())
+		if err != nil {
+			return "", false, fmt.Errorf("error encountered attempting KMS encryption of \"%s\": %v", value.Key(), err)
+		}
+
+		return envelope, true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if matched == 0 {
+		fmt.Fprintf(os.Stderr, "warning: no unencrypted values in \"%s\" matched the selected keys\n", cfg.File)
+	}
+
+	cfg.Out.Write([]byte(output))
+
+	return nil
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 
 	"github.com/meltwater/dragoman/cryptography"
 )
@@ -22,8 +23,8 @@ func processKmsEncrypt(cfg *encryptConfig) error {
 	}
 
 	var strategy *cryptography.KmsCryptoStrategy
-	if strategy, err = cryptography.NewKmsCryptoStrategy(cfg.AwsRegion); err != nil {
-		return fmt.Errorf("unable to create kms crypto strategy: %v", err)
+	if strategy, err = newKmsEncryptStrategy(cfg, false); err != nil {
+		return err
 	}
 
 	var envelope string
@@ -43,3 +44,61 @@ func processKmsEncrypt(cfg *encryptConfig) error {
 
 	return nil
 }
+
+// newKmsEncryptStrategy sets up the KMS strategy of the encrypt command. In deterministic mode the data key of the
+// envelopes being replaced is picked up, so values that did not change are encrypted to the same envelopes again.
+func newKmsEncryptStrategy(cfg *encryptConfig, reuseDataKeys bool) (*cryptography.KmsCryptoStrategy, error) {
+	strategy, err := newKmsStrategy(cfg.AwsRegion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kms crypto strategy: %v", err)
+	}
+
+	if !cfg.Deterministic {
+		if reuseDataKeys {
+			strategy = strategy.WithDataKeyReuse()
+		}
+
+		return strategy.WithMetadata(cfg.Metadata), nil
+	}
+
+	strategy = strategy.WithDeterministicNonces().WithMetadata(cfg.Metadata)
+
+	if cfg.Previous == nil {
+		fmt.Fprintf(os.Stderr, "warning: there is no earlier file to reuse the data key of, write the output with --output or --in-place to get the same envelopes next time\n")
+	} else if _, err = strategy.ReuseDataKey(cfg.Key, cfg.Previous); err != nil {
+		return nil, err
+	}
+
+	return strategy, nil
+}
+
+// previousEnvelopes collects the envelopes of the files a deterministic encryption replaces or reads, nil when
+// there is no such file. Files that do not exist yet are skipped.
+func previousEnvelopes(paths ...string) ([]string, error) {
+	var envelopes []string
+	seen := map[string]bool{}
+
+	for _, path := range paths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		if envelopes == nil {
+			envelopes = []string{}
+		}
+
+		contents, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read \"%s\": %v", path, err)
+		}
+
+		for _, envelope := range cryptography.FindEnvelopes(string(contents)) {
+			envelopes = append(envelopes, envelope.Value())
+		}
+	}
+
+	return envelopes, nil
+}
@@ -14,12 +14,33 @@ func processSMEncrypt(cfg *encryptConfig) error {
 	}
 
 	var strategy *cryptography.SecretsManagerCryptoStrategy
-	if strategy, err = cryptography.NewSecretsManagerCryptoStrategy(cfg.AwsRegion); err != nil {
+	if strategy, err = newSecretsManagerStrategy(cfg.AwsRegion); err != nil {
 		return fmt.Errorf("unable to create secrets manager crypto strategy: %v", err)
 	}
+	strategy = strategy.WithMetadata(cfg.Metadata)
+
+	if cfg.SecretVersion.Id != "" && cfg.SecretVersion.Stage != "" {
+		return fmt.Errorf("only one of --sm-version-id and --sm-version-stage can be provided")
+	}
+
+	// References with placeholders are kept as they are, validation checks what they expand to now
+	if cfg.Validate {
+		var secretId, secretKey string
+		if secretId, err = cfg.Variables.Expand(cfg.Key); err != nil {
+			return err
+		}
+
+		if secretKey, err = cfg.Variables.Expand(cfg.SecretKey); err != nil {
+			return err
+		}
+
+		if err = strategy.ValidateReference(secretId, secretKey, cfg.SecretVersion); err != nil {
+			return err
+		}
+	}
 
 	var envelope string
-	if envelope, err = strategy.Encrypt([]byte(cfg.Key), cfg.SecretKey); err != nil {
+	if envelope, err = strategy.EncryptVersion([]byte(cfg.Key), cfg.SecretKey, cfg.SecretVersion); err != nil {
 		return fmt.Errorf("error encountered attempting secrets manager encryption: %v", err)
 	}
 
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/meltwater/dragoman/formats"
+	"github.com/spf13/cobra"
+)
+
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envCmd represents the env command
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Print the decrypted variables of a dotenv file or YAML map for shells, dotenv or JSON",
+	Long: `Read variables from a dotenv file or a flat YAML map, decrypt any envelopes they
+contain and print them in a form that can be consumed directly.
+
+The shell format prints export statements with correct quoting, so the output can
+be evaluated by a shell. The dotenv format prints KEY="value" lines and the json
+format prints a single object.
+
+Examples:
+
+eval "$(dragoman env -i secrets.env)"
+dragoman env -i secrets.yaml --format dotenv > .env
+dragoman env -i secrets.env --format json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var input io.Reader = os.Stdin
+
+		fname, _ := cmd.Flags().GetString("input")
+		if fname != "" {
+			var ferr error
+			if input, ferr = os.Open(fname); ferr != nil {
+				panic(fmt.Errorf("unable to open file \"%s\": %v", fname, ferr))
+			}
+		}
+
+		// The input format comes from the flag, then the file name, and defaults to dotenv for standard in
+		inputFormat := formats.Dotenv
+		if name, _ := cmd.Flags().GetString("input-format"); name != "" {
+			var err error
+			if inputFormat, err = formats.ParseFormat(name); err != nil {
+				panic(err)
+			}
+		} else if fname != "" {
+			if detected, err := formats.DetectFormat(fname); err == nil {
+				inputFormat = detected
+			}
+		}
+
+		outputFormat, _ := cmd.Flags().GetString("format")
+
+		if err := processEnv(input, os.Stdout, inputFormat, outputFormat); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+
+	envCmd.Flags().StringP("input", "i", "", "An optional dotenv or YAML file to read the variables from")
+	envCmd.Flags().String("input-format", "", "The format of the input, dotenv or yaml. Detected from the file name by default")
+	envCmd.Flags().String("format", "shell", "Output format: shell, dotenv or json")
+}
+
+func processEnv(in io.Reader, out io.Writer, inputFormat formats.Format, outputFormat string) error {
+	if inputFormat != formats.Dotenv && inputFormat != formats.YAML {
+		return fmt.Errorf("variables can only be read from dotenv or yaml, not %s", inputFormat)
+	}
+
+	payload, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("unable to read input: %v", err)
+	}
+
+	var values []formats.Value
+	if values, err = formats.FindValues(inputFormat, string(payload)); err != nil {
+		return err
+	}
+
+	var strategy cryptography.Decryptor
+	names := make([]string, 0, len(values))
+	vars := map[string]string{}
+
+	for _, value := range values {
+		if len(value.Path) != 1 {
+			return fmt.Errorf("\"%s\" is nested, only flat maps of variables are supported", value.Key())
+		}
+
+		name := value.Path[0]
+		if outputFormat != "json" && !envNameRegex.MatchString(name) {
+			return fmt.Errorf("\"%s\" is not a valid variable name", name)
+		}
+
+		if _, exists := vars[name]; !exists {
+			names = append(names, name)
+		}
+
+		if vars[name], err = decryptValue(value.Text, &strategy); err != nil {
+			return fmt.Errorf("unable to decrypt %s: %v", name, err)
+		}
+	}
+
+	switch outputFormat {
+	case "shell":
+		for _, name := range names {
+			fmt.Fprintf(out, "export %s=%s\n", name, formats.QuoteShell(vars[name]))
+		}
+	case "dotenv":
+		for _, name := range names {
+			fmt.Fprintf(out, "%s=%s\n", name, formats.QuoteDotenv(vars[name]))
+		}
+	case "json":
+		// Keep the order of the input rather than the sorted order of encoding/json
+		buff := &bytes.Buffer{}
+		buff.WriteString("{")
+		for i, name := range names {
+			if i > 0 {
+				buff.WriteString(",")
+			}
+			fmt.Fprintf(buff, "\n  %s: %s", formats.QuoteJSON(name), formats.QuoteJSON(vars[name]))
+		}
+		buff.WriteString("\n}\n")
+
+		out.Write(buff.Bytes())
+	default:
+		return fmt.Errorf("unknown output format \"%s\", expected shell, dotenv or json", outputFormat)
+	}
+
+	return nil
+}
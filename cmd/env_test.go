@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/meltwater/dragoman/formats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessEnv(t *testing.T) {
+	t.Run("it should print the decrypted variables in every output format", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("").withKey(testKeyArn, testKeyArn)
+		mocks.sm("").withSecret("app/token", "it's \"quoted\"")
+
+		input := "ZED=" + kmsEnvelope(t, mocks.kms(""), testKeyArn, "hunter2", nil) + "\n" +
+			"TOKEN=" + smEnvelope(t, "app/token", nil) + "\n" +
+			"PLAIN=as is\n"
+
+		for outputFormat, expected := range map[string]string{
+			"shell":  "export ZED='hunter2'\nexport TOKEN='it'\\''s \"quoted\"'\nexport PLAIN='as is'\n",
+			"dotenv": "ZED=\"hunter2\"\nTOKEN=\"it's \\\"quoted\\\"\"\nPLAIN=\"as is\"\n",
+			"json":   "{\n  \"ZED\": \"hunter2\",\n  \"TOKEN\": \"it's \\\"quoted\\\"\",\n  \"PLAIN\": \"as is\"\n}\n",
+		} {
+			out := &bytes.Buffer{}
+			assert.Nil(t, processEnv(strings.NewReader(input), out, formats.Dotenv, outputFormat))
+			assert.Equal(t, expected, out.String(), outputFormat)
+		}
+	})
+
+	t.Run("it should read flat YAML maps", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("").withKey(testKeyArn, testKeyArn)
+
+		input := "DB_PASSWORD: " + kmsEnvelope(t, mocks.kms(""), testKeyArn, "hunter2", nil) + "\nPORT: 5432\n"
+
+		out := &bytes.Buffer{}
+		assert.Nil(t, processEnv(strings.NewReader(input), out, formats.YAML, "dotenv"))
+		assert.Equal(t, "DB_PASSWORD=\"hunter2\"\nPORT=\"5432\"\n", out.String())
+	})
+
+	t.Run("it should refuse input it cannot turn into variables before decrypting", func(t *testing.T) {
+		mocks := mockAws(t)
+
+		for _, test := range []struct {
+			input        string
+			inputFormat  formats.Format
+			outputFormat string
+			expected     string
+		}{
+			{"db:\n  password: x\n", formats.YAML, "shell", `"db.password" is nested, only flat maps of variables are supported`},
+			{"not-a-name: x\n", formats.YAML, "shell", `"not-a-name" is not a valid variable name`},
+			{"{}", formats.JSON, "shell", "variables can only be read from dotenv or yaml, not json"},
+			{"A=b\n", formats.Dotenv, "xml", `unknown output format "xml", expected shell, dotenv or json`},
+		} {
+			out := &bytes.Buffer{}
+			assert.EqualError(t, processEnv(strings.NewReader(test.input), out, test.inputFormat, test.outputFormat), test.expected)
+			assert.Empty(t, out.String())
+		}
+
+		assert.Empty(t, mocks.kmsClients)
+		assert.Empty(t, mocks.smClients)
+	})
+
+	t.Run("it should accept any name in JSON output", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		assert.Nil(t, processEnv(strings.NewReader("not-a-name: x\n"), out, formats.YAML, "json"))
+		assert.Equal(t, "{\n  \"not-a-name\": \"x\"\n}\n", out.String())
+	})
+
+	t.Run("it should name the variable that cannot be decrypted", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.sm("").withMissingSecrets()
+
+		out := &bytes.Buffer{}
+		err := processEnv(strings.NewReader("TOKEN="+smEnvelope(t, "app/gone", nil)+"\n"), out, formats.Dotenv, "shell")
+
+		assert.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "unable to decrypt TOKEN: "), err.Error())
+		assert.Empty(t, out.String())
+	})
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/meltwater/dragoman/formats"
+	"github.com/spf13/cobra"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [--env-file file] -- command [args...]",
+	Short: "Run a command with the envelopes in its environment decrypted",
+	Long: `Run a command with every envelope found in its environment variables decrypted.
+
+The current environment, and optionally dotenv files given with --env-file, are
+scanned for envelopes. They are decrypted in memory and the command is started with
+the plaintext values, which never touch the disk. Values from --env-file take
+precedence over the current environment, and later files over earlier ones.
+
+Signals received by dragoman are forwarded to the command and the command's exit
+code is returned as dragoman's own.
+
+Examples:
+
+dragoman exec -- ./server --port 8080
+dragoman exec --env-file secrets.env -- ./server`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		envFiles, err := cmd.Flags().GetStringSlice("env-file")
+		if err != nil {
+			panic(err)
+		}
+
+		var env []string
+		if env, err = decryptEnvironment(os.Environ(), envFiles); err != nil {
+			panic(err)
+		}
+
+		var code int
+		if code, err = runCommand(args, env); err != nil {
+			panic(err)
+		}
+
+		os.Exit(code)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	// Everything after the command name belongs to the command
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().StringSlice("env-file", nil, "A dotenv file with extra variables to decrypt and pass to the command")
+}
+
+// decryptEnvironment merges the env files into the environment and decrypts every value holding an envelope
+func decryptEnvironment(environ []string, envFiles []string) ([]string, error) {
+	vars := map[string]string{}
+	for _, entry := range environ {
+		if i := strings.IndexByte(entry, '='); i > 0 {
+			vars[entry[:i]] = entry[i+1:]
+		}
+	}
+
+	for _, fname := range envFiles {
+		contents, err := os.ReadFile(fname)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read env file \"%s\": %v", fname, err)
+		}
+
+		var values []formats.Value
+		if values, err = formats.FindValues(formats.Dotenv, string(contents)); err != nil {
+			return nil, fmt.Errorf("unable to read env file \"%s\": %v", fname, err)
+		}
+
+		for _, value := range values {
+			vars[value.Path[0]] = value.Text
+		}
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var strategy cryptography.Decryptor
+	env := make([]string, 0, len(names))
+
+	for _, name := range names {
+		value, err := decryptValue(vars[name], &strategy)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt $%s: %v", name, err)
+		}
+
+		env = append(env, name+"="+value)
+	}
+
+	return env, nil
+}
+
+// runCommand starts the command, forwards signals to it until it exits and returns its exit code
+func runCommand(args []string, env []string) (int, error) {
+	child := exec.Command(args[0], args[1:]...)
+	child.Env = env
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := child.Start(); err != nil {
+		return 0, fmt.Errorf("unable to start \"%s\": %v", args[0], err)
+	}
+
+	go func() {
+		for sig := range signals {
+			child.Process.Signal(sig)
+		}
+	}()
+
+	err := child.Wait()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return 0, fmt.Errorf("unable to wait for \"%s\": %v", args[0], err)
+	}
+
+	return exitCode(child.ProcessState), nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptEnvironment(t *testing.T) {
+	t.Run("it should decrypt the environment and env files, the later files winning", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.kms("").withKey(testKeyArn, testKeyArn)
+		mocks.sm("").withSecret("app/token", "s3cr3t")
+
+		dir := t.TempDir()
+		first := filepath.Join(dir, "first.env")
+		second := filepath.Join(dir, "second.env")
+		assert.Nil(t, os.WriteFile(first, []byte("DB_PASSWORD="+kmsEnvelope(t, mocks.kms(""), testKeyArn, "hunter2", nil)+"\nMODE=first\n"), 0600))
+		assert.Nil(t, os.WriteFile(second, []byte("# overrides\nMODE=second\nURL=\"postgres://app:"+smEnvelope(t, "app/token", nil)+"@db\"\n"), 0600))
+
+		env, err := decryptEnvironment([]string{
+			"PATH=/usr/bin",
+			"MODE=environ",
+			"API_KEY=" + kmsEnvelope(t, mocks.kms(""), testKeyArn, "key=with=equals", nil),
+			"MALFORMED",
+		}, []string{first, second})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"API_KEY=key=with=equals",
+			"DB_PASSWORD=hunter2",
+			"MODE=second",
+			"PATH=/usr/bin",
+			"URL=postgres://app:s3cr3t@db",
+		}, env)
+	})
+
+	t.Run("it should not set up AWS clients without envelopes", func(t *testing.T) {
+		mocks := mockAws(t)
+
+		env, err := decryptEnvironment([]string{"PATH=/usr/bin", "HOME=/root"}, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"HOME=/root", "PATH=/usr/bin"}, env)
+		assert.Empty(t, mocks.kmsClients)
+		assert.Empty(t, mocks.smClients)
+	})
+
+	t.Run("it should name the variable that cannot be decrypted", func(t *testing.T) {
+		mocks := mockAws(t)
+		mocks.sm("").withMissingSecrets()
+
+		_, err := decryptEnvironment([]string{"TOKEN=" + smEnvelope(t, "app/gone", nil)}, nil)
+
+		assert.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "unable to decrypt $TOKEN: "), err.Error())
+	})
+
+	t.Run("it should fail on env files that cannot be read", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing.env")
+
+		_, err := decryptEnvironment(nil, []string{missing})
+
+		assert.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "unable to read env file \""+missing+"\": "), err.Error())
+	})
+}
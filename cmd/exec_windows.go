@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import (
+	"os"
+)
+
+// forwardedSignals are relayed from dragoman to the command started by exec
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+}
+
+func exitCode(state *os.ProcessState) int {
+	return state.ExitCode()
+}
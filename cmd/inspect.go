@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// inspectCmd represents the inspect command
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [file...]",
+	Short: "List the envelopes in the provided files (or standard in) without decrypting them",
+	Long: `List every envelope found in the provided files, or standard in when no files are given.
+
+For each envelope the file, line, column, strategy, format version, size and the
+KMS key ARN or Secrets Manager secret reference are reported, along with the creation
+time, expiry, creator and note recorded by newer versions of dragoman. Nothing is
+decrypted and no AWS APIs are called, so no decrypt rights are needed. The metadata of
+KMS envelopes is only checked for tampering when they are decrypted.
+
+Examples:
+
+dragoman inspect config/*.yaml
+dragoman inspect --format json config/prod.yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			panic(err)
+		}
+
+		records := []inspectRecord{}
+		if len(args) == 0 {
+			if records, err = inspectReader("<stdin>", os.Stdin); err != nil {
+				panic(err)
+			}
+		}
+
+		for _, fname := range args {
+			var file *os.File
+			if file, err = os.Open(fname); err != nil {
+				panic(fmt.Errorf("unable to open file \"%s\": %v", fname, err))
+			}
+
+			var fileRecords []inspectRecord
+			fileRecords, err = inspectReader(fname, file)
+			file.Close()
+			if err != nil {
+				panic(err)
+			}
+
+			records = append(records, fileRecords...)
+		}
+
+		if err = writeInspectRecords(os.Stdout, format, records); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().String("format", "table", "Output format, either table or json")
+}
+
+type inspectRecord struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	*cryptography.EnvelopeInfo
+	Error string `json:"error,omitempty"`
+}
+
+func inspectReader(fname string, in io.Reader) ([]inspectRecord, error) {
+	contents, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read \"%s\": %v", fname, err)
+	}
+
+	envelopes := cryptography.FindEnvelopes(string(contents))
+	records := make([]inspectRecord, 0, len(envelopes))
+
+	for _, envelope := range envelopes {
+		record := inspectRecord{
+			File:   fname,
+			Line:   envelope.Line,
+			Column: envelope.Column,
+		}
+
+		if record.EnvelopeInfo, err = cryptography.InspectEnvelope(envelope.Value()); err != nil {
+			record.EnvelopeInfo = &cryptography.EnvelopeInfo{Strategy: envelope.Type}
+			record.Error = err.Error()
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func writeInspectRecords(out io.Writer, format string, records []inspectRecord) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(records)
+	case "table":
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "FILE\tLINE\tCOLUMN\tSTRATEGY\tVERSION\tKEY / SECRET\tSECRET KEY\tSIZE\tCREATED\tEXPIRES\tCREATOR\tNOTE")
+
+		now := time.Now()
+		for _, r := range records {
+			if r.Error != "" {
+				fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t-\tinvalid: %s\t-\t-\t-\t-\t-\t-\n", r.File, r.Line, r.Column, r.Strategy, r.Error)
+				continue
+			}
+
+			reference := orDash(r.SecretId)
+			if pinned := r.SecretVersion + r.SecretStage; pinned != "" {
+				reference += " @ " + pinned
+			}
+			if r.Strategy == cryptography.CRYPTO_KEY_KMS {
+				reference = orDash(r.KeyId)
+				if r.Metadata != nil && r.Metadata.KeyAlias != "" {
+					reference += " (" + r.Metadata.KeyAlias + ")"
+				}
+			}
+
+			created, expires, creator, note := "-", "-", "-", "-"
+			if m := r.Metadata; m != nil {
+				creator, note = orDash(m.Creator), orDash(m.Note)
+
+				// Deterministic envelopes do not record when they were created
+				if !m.Created.IsZero() {
+					created = m.Created.Format(time.RFC3339)
+				}
+
+				if m.Expires != nil {
+					expires = m.Expires.Format(time.RFC3339)
+					if m.Expired(now) {
+						expires += " (expired)"
+					}
+				}
+			}
+
+			size := strconv.Itoa(r.Size)
+			if r.PlaintextSize != nil {
+				size = fmt.Sprintf("%d (plaintext %d)", r.Size, *r.PlaintextSize)
+			}
+
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.File, r.Line, r.Column, r.Strategy, r.Version, reference, orDash(r.SecretKey), size, created, expires, creator, note)
+		}
+
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format \"%s\", expected table or json", format)
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// addMetadataFlags registers the flags read by newEnvelopeMetadata
+func addMetadataFlags(cmd *cobra.Command) {
+	cmd.Flags().String("expires", "", "Record when the new envelopes expire: a date (2006-01-02), an RFC 3339 time or a duration from now such as 90d or 720h")
+	cmd.Flags().String("note", "", "Record a free-form note in the new envelopes")
+}
+
+// newEnvelopeMetadata describes the envelopes a command is about to create. The creator is looked up with STS,
+// when that fails the envelopes are created without one and a warning is printed.
+func newEnvelopeMetadata(cmd *cobra.Command, awsRegion string) (*cryptography.EnvelopeMetadata, error) {
+	now := time.Now().UTC()
+	metadata := &cryptography.EnvelopeMetadata{Created: now.Truncate(time.Second)}
+
+	metadata.Note, _ = cmd.Flags().GetString("note")
+
+	if expires, _ := cmd.Flags().GetString("expires"); expires != "" {
+		at, err := parseExpiry(expires, now)
+		if err != nil {
+			return nil, err
+		}
+
+		metadata.Expires = &at
+	}
+
+	// Deterministic envelopes only record what was given on the command line, so encrypting again gives the same
+	// envelope. Relative expiries still change from one run to the next.
+	if deterministic, _ := cmd.Flags().GetBool("deterministic"); deterministic {
+		if metadata.Note == "" && metadata.Expires == nil {
+			return nil, nil
+		}
+
+		metadata.Created = time.Time{}
+		return metadata, nil
+	}
+
+	// Without a region the command fails before any envelope is created
+	if awsRegion == "" {
+		return metadata, nil
+	}
+
+	metadata.Creator = lookupCreator(awsRegion)
+
+	return metadata, nil
+}
+
+// lookupCreator returns the ARN of the AWS identity creating envelopes, or warns and returns nothing when STS fails
+func lookupCreator(awsRegion string) string {
+	creator, err := cryptography.CallerIdentity(awsRegion)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: the creator is not recorded in the new envelopes: %v\n", err)
+	}
+
+	return creator
+}
+
+// envelopeMetadata returns the metadata recorded in an envelope, if any
+func envelopeMetadata(envelope cryptography.Envelope) *cryptography.EnvelopeMetadata {
+	info, err := cryptography.InspectEnvelope(envelope.Value())
+	if err != nil {
+		return nil
+	}
+
+	return info.Metadata
+}
+
+// replacedMetadata is the metadata of an envelope holding a new value in place of another one. The expiry, note
+// and key alias of the original are kept, while the creation time and creator are the replacement's.
+func replacedMetadata(original *cryptography.EnvelopeMetadata, replacement *cryptography.EnvelopeMetadata) *cryptography.EnvelopeMetadata {
+	if original == nil || replacement == nil {
+		return replacement
+	}
+
+	kept := *original
+	kept.Created = replacement.Created
+	kept.Creator = replacement.Creator
+
+	return &kept
+}
+
+// movedMetadata is the metadata of an envelope holding the same value as another one under a different key or in
+// a different kind of envelope. The value is as old as it was, so the original metadata is kept apart from the key
+// alias, with the expiry and note given on the command line taking precedence. Envelopes without metadata get the
+// given metadata.
+func movedMetadata(original *cryptography.EnvelopeMetadata, given *cryptography.EnvelopeMetadata) *cryptography.EnvelopeMetadata {
+	if original == nil {
+		return given
+	}
+
+	kept := *original
+	kept.KeyAlias = ""
+
+	if given != nil && given.Expires != nil {
+		kept.Expires = given.Expires
+	}
+
+	if given != nil && given.Note != "" {
+		kept.Note = given.Note
+	}
+
+	return &kept
+}
+
+// parseExpiry reads a date, an RFC 3339 time or a duration from now, where a "d" suffix counts days.
+// Dates and times that have already passed are refused, as the envelopes would be expired from the start.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		at, err = time.Parse("2006-01-02", value)
+	}
+
+	if err == nil {
+		if !at.After(now) {
+			return time.Time{}, fmt.Errorf("the expiry \"%s\" has already passed", value)
+		}
+
+		return at.UTC(), nil
+	}
+
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, n).Truncate(time.Second), nil
+		}
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return now.Add(duration).Truncate(time.Second), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry \"%s\", expected a date (2006-01-02), an RFC 3339 time or a duration such as 90d", value)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 30, 15, 500, time.UTC)
+
+	for _, test := range []struct {
+		value    string
+		expected time.Time
+		err      string
+	}{
+		{value: "2027-01-01", expected: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2026-06-02T08:00:00+02:00", expected: time.Date(2026, 6, 2, 6, 0, 0, 0, time.UTC)},
+		{value: "90d", expected: time.Date(2026, 8, 30, 12, 30, 15, 0, time.UTC)},
+		{value: "720h", expected: time.Date(2026, 7, 1, 12, 30, 15, 0, time.UTC)},
+		{value: "1h30m", expected: time.Date(2026, 6, 1, 14, 0, 15, 0, time.UTC)},
+		{value: "2026-06-01", err: `the expiry "2026-06-01" has already passed`},
+		{value: "2025-12-31", err: `the expiry "2025-12-31" has already passed`},
+		{value: "2026-06-01T12:00:00Z", err: `the expiry "2026-06-01T12:00:00Z" has already passed`},
+		{value: "0d", err: `invalid expiry "0d", expected a date (2006-01-02), an RFC 3339 time or a duration such as 90d`},
+		{value: "-5d", err: `invalid expiry "-5d", expected a date (2006-01-02), an RFC 3339 time or a duration such as 90d`},
+		{value: "-1h", err: `invalid expiry "-1h", expected a date (2006-01-02), an RFC 3339 time or a duration such as 90d`},
+		{value: "2026-02-30", err: `invalid expiry "2026-02-30", expected a date (2006-01-02), an RFC 3339 time or a duration such as 90d`},
+		{value: "01/02/2027", err: `invalid expiry "01/02/2027", expected a date (2006-01-02), an RFC 3339 time or a duration such as 90d`},
+		{value: "soon", err: `invalid expiry "soon", expected a date (2006-01-02), an RFC 3339 time or a duration such as 90d`},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			at, err := parseExpiry(test.value, now)
+
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, at)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// commandOutput buffers the output of a command so nothing is written until the command succeeded.
+// Commit then writes it to standard out, or atomically to the --output or --in-place file.
+type commandOutput struct {
+	bytes.Buffer
+	path      string // Empty for standard out
+	plaintext bool   // The output holds decrypted secrets
+	backup    string // Suffix of a copy of the replaced file, none when empty
+}
+
+// addOutputFlags registers the flags read by newCommandOutput
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", "", "Write the output to this file instead of standard out")
+	cmd.Flags().Bool("in-place", false, "Replace the input file with the output")
+	cmd.Flags().String("backup", "", "Keep a copy of the file being replaced with this suffix, for example .bak")
+}
+
+// newCommandOutput reads the output flags. input is the file the command reads, which --in-place replaces.
+// Files holding plaintext are always written with mode 0600, other files keep the mode of the file they replace.
+func newCommandOutput(cmd *cobra.Command, input string, plaintext bool) (*commandOutput, error) {
+	path, _ := cmd.Flags().GetString("output")
+	inPlace, _ := cmd.Flags().GetBool("in-place")
+	backup, _ := cmd.Flags().GetString("backup")
+
+	if inPlace {
+		if path != "" {
+			return nil, fmt.Errorf("only one of --output and --in-place can be provided")
+		}
+
+		if input == "" {
+			return nil, fmt.Errorf("--in-place needs an input file")
+		}
+
+		path = input
+	}
+
+	if backup != "" && path == "" {
+		return nil, fmt.Errorf("--backup needs --output or --in-place")
+	}
+
+	return &commandOutput{path: path, plaintext: plaintext, backup: backup}, nil
+}
+
+// Replaces reports whether committing the output overwrites the file, as --in-place does
+func (o *commandOutput) Replaces(file string) bool {
+	return file != "" && filepath.Clean(o.path) == filepath.Clean(file)
+}
+
+// Commit writes the buffered output to its destination
+func (o *commandOutput) Commit() error {
+	if o.path == "" {
+		_, err := os.Stdout.Write(o.Bytes())
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	if o.plaintext {
+		perm = 0600
+	}
+
+	info, err := os.Stat(o.path)
+	switch {
+	case err == nil:
+		if !o.plaintext {
+			perm = info.Mode().Perm()
+		}
+
+		if o.backup != "" {
+			if err = o.backupFile(info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("unable to read \"%s\": %v", o.path, err)
+	}
+
+	return writeFileAtomic(o.path, o.Bytes(), perm)
+}
+
+// backupFile copies the file about to be replaced. When the new content is encrypted the old content
+// was most likely plaintext, so that copy is only readable by the owner.
+func (o *commandOutput) backupFile(perm os.FileMode) error {
+	original, err := os.ReadFile(o.path)
+	if err != nil {
+		return fmt.Errorf("unable to read \"%s\" for a backup: %v", o.path, err)
+	}
+
+	if !o.plaintext {
+		perm = 0600
+	}
+
+	if err = writeFileAtomic(o.path+o.backup, original, perm); err != nil {
+		return fmt.Errorf("unable to back up \"%s\": %v", o.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+// outputTo buffers the content for the file like a command writing with the output flags would
+func outputTo(t *testing.T, input string, plaintext bool, content string, flags ...string) *commandOutput {
+	cmd := &cobra.Command{}
+	addOutputFlags(cmd)
+	assert.Nil(t, cmd.Flags().Parse(flags))
+
+	output, err := newCommandOutput(cmd, input, plaintext)
+	assert.Nil(t, err)
+
+	output.WriteString(content)
+
+	return output
+}
+
+func assertFile(t *testing.T, path string, content string, perm os.FileMode) {
+	actual, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, content, string(actual))
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, perm, info.Mode().Perm())
+}
+
+func TestNewCommandOutput(t *testing.T) {
+	for expected, flags := range map[string][]string{
+		"only one of --output and --in-place can be provided": {"--output", "out.yaml", "--in-place"},
+		"--backup needs --output or --in-place":               {"--backup", ".bak"},
+	} {
+		cmd := &cobra.Command{}
+		addOutputFlags(cmd)
+		assert.Nil(t, cmd.Flags().Parse(flags))
+
+		_, err := newCommandOutput(cmd, "values.yaml", false)
+		assert.EqualError(t, err, expected)
+	}
+
+	t.Run("it should need an input file to replace", func(t *testing.T) {
+		cmd := &cobra.Command{}
+		addOutputFlags(cmd)
+		assert.Nil(t, cmd.Flags().Parse([]string{"--in-place"}))
+
+		_, err := newCommandOutput(cmd, "", false)
+		assert.EqualError(t, err, "--in-place needs an input file")
+	})
+}
+
+func TestCommandOutputCommit(t *testing.T) {
+	t.Run("it should write plaintext with mode 0600", func(t *testing.T) {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "values.yaml")
+		assert.Nil(t, os.WriteFile(input, []byte("a: ENC[KMS,x]\n"), 0644))
+
+		assert.Nil(t, outputTo(t, input, true, "a: secret\n", "--output", filepath.Join(dir, "new.yaml")).Commit())
+		assertFile(t, filepath.Join(dir, "new.yaml"), "a: secret\n", 0600)
+
+		assert.Nil(t, outputTo(t, input, true, "a: secret\n", "--in-place").Commit())
+		assertFile(t, input, "a: secret\n", 0600)
+	})
+
+	t.Run("it should keep the mode of the file encrypted output replaces", func(t *testing.T) {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "values.yaml")
+		assert.Nil(t, os.WriteFile(input, []byte("a: secret\n"), 0640))
+
+		assert.Nil(t, outputTo(t, input, false, "a: ENC[KMS,x]\n", "--in-place").Commit())
+		assertFile(t, input, "a: ENC[KMS,x]\n", 0640)
+
+		assert.Nil(t, outputTo(t, input, false, "a: ENC[KMS,x]\n", "--output", filepath.Join(dir, "new.yaml")).Commit())
+		assertFile(t, filepath.Join(dir, "new.yaml"), "a: ENC[KMS,x]\n", 0644)
+	})
+
+	t.Run("it should back up the plaintext an encryption replaces with mode 0600", func(t *testing.T) {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "values.yaml")
+		assert.Nil(t, os.WriteFile(input, []byte("a: secret\n"), 0644))
+		assert.Nil(t, os.Chmod(input, 0644))
+
+		assert.Nil(t, outputTo(t, input, false, "a: ENC[KMS,x]\n", "--in-place", "--backup", ".bak").Commit())
+
+		assertFile(t, input, "a: ENC[KMS,x]\n", 0644)
+		assertFile(t, input+".bak", "a: secret\n", 0600)
+	})
+
+	t.Run("it should back up the envelopes a decryption replaces with their mode", func(t *testing.T) {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "values.yaml")
+		assert.Nil(t, os.WriteFile(input, []byte("a: ENC[KMS,x]\n"), 0644))
+		assert.Nil(t, os.Chmod(input, 0644))
+
+		assert.Nil(t, outputTo(t, input, true, "a: secret\n", "--in-place", "--backup", ".orig").Commit())
+
+		assertFile(t, input, "a: secret\n", 0600)
+		assertFile(t, input+".orig", "a: ENC[KMS,x]\n", 0644)
+	})
+
+	t.Run("it should leave the input untouched when the backup fails", func(t *testing.T) {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "values.yaml")
+		assert.Nil(t, os.WriteFile(input, []byte("a: secret\n"), 0644))
+		assert.Nil(t, os.Chmod(input, 0644))
+
+		err := outputTo(t, input, false, "a: ENC[KMS,x]\n", "--in-place", "--backup", "/missing/.bak").Commit()
+		assert.Error(t, err)
+
+		assertFile(t, input, "a: secret\n", 0644)
+
+		// No temporary files are left behind either
+		entries, err := os.ReadDir(dir)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("it should leave the input untouched when it cannot be replaced", func(t *testing.T) {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "values.yaml")
+		assert.Nil(t, os.WriteFile(input, []byte("a: secret\n"), 0644))
+		assert.Nil(t, os.Chmod(input, 0644))
+
+		// The output cannot be renamed over a directory
+		target := filepath.Join(dir, "target")
+		assert.Nil(t, os.Mkdir(target, 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(target, "keep"), nil, 0644))
+
+		err := outputTo(t, input, false, "a: ENC[KMS,x]\n", "--output", target).Commit()
+		assert.Error(t, err)
+
+		assertFile(t, input, "a: secret\n", 0644)
+
+		entries, err := os.ReadDir(dir)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2)
+	})
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// decryptionPolicy restricts the keys and secrets envelopes may use when decrypting, nil when none is configured
+var decryptionPolicy *cryptography.Policy
+
+// policyFile is the content of a --policy file
+type policyFile struct {
+	KmsKeys   []string `yaml:"kms_keys"`
+	SecretIds []string `yaml:"secret_ids"`
+}
+
+// addPolicyFlags registers the flags read by configurePolicy
+func addPolicyFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("policy", os.Getenv("DRAGOMAN_POLICY"), "A YAML file listing the kms_keys and secret_ids envelopes may use when decrypting, types without entries are refused. Defaults to $DRAGOMAN_POLICY")
+	cmd.PersistentFlags().StringSlice("allow-kms-key", []string{}, "A KMS key ARN, key id, alias or ARN pattern envelopes may use when decrypting, added to --policy. '*' allows any key")
+	cmd.PersistentFlags().StringSlice("allow-secret", []string{}, "A Secrets Manager secret name, ARN or pattern such as 'prod/app/*' envelopes may use when decrypting, added to --policy. '*' allows any secret")
+}
+
+// configurePolicy sets up the decryption policy from --policy, --allow-kms-key and --allow-secret
+func configurePolicy(cmd *cobra.Command) error {
+	fname, _ := cmd.Flags().GetString("policy")
+	kmsKeys, _ := cmd.Flags().GetStringSlice("allow-kms-key")
+	secretIds, _ := cmd.Flags().GetStringSlice("allow-secret")
+
+	decryptionPolicy = nil
+
+	if fname != "" {
+		file, err := loadPolicyFile(fname)
+		if err != nil {
+			return err
+		}
+
+		kmsKeys = append(kmsKeys, file.KmsKeys...)
+		secretIds = append(secretIds, file.SecretIds...)
+	}
+
+	if len(kmsKeys) == 0 && len(secretIds) == 0 {
+		return nil
+	}
+
+	// Aliases are resolved in the region of the key they are checked against, an alias names another key elsewhere
+	strategies := kmsStrategies{}
+
+	decryptionPolicy = &cryptography.Policy{
+		KmsKeys:   trimEntries(kmsKeys),
+		SecretIds: trimEntries(secretIds),
+		Variables: referenceVariables,
+		ResolveAlias: func(alias string, region string) (string, error) {
+			strategy, err := strategies.forRegion(region)
+			if err != nil {
+				return "", err
+			}
+
+			return strategy.ResolveKeyArn(alias)
+		},
+	}
+
+	return nil
+}
+
+func loadPolicyFile(fname string) (*policyFile, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the policy \"%s\": %v", fname, err)
+	}
+	defer file.Close()
+
+	policy := &policyFile{}
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+
+	if err = decoder.Decode(policy); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to parse the policy \"%s\": %v", fname, err)
+	}
+
+	// A policy that allows nothing is most likely a mistake, refuse it rather than allowing everything
+	if len(policy.KmsKeys) == 0 && len(policy.SecretIds) == 0 {
+		return nil, fmt.Errorf("the policy \"%s\" lists no kms_keys or secret_ids", fname)
+	}
+
+	return policy, nil
+}
+
+func trimEntries(entries []string) []string {
+	trimmed := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			trimmed = append(trimmed, entry)
+		}
+	}
+
+	return trimmed
+}
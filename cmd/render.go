@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render a Go template with helpers to decrypt and look up secrets",
+	Long: `Render a Go text/template, from the --template file or standard in, to standard out.
+
+On top of the standard template functions the following are available:
+
+  decrypt "ENC[...]"       Decrypt every envelope in the string
+  secman "id" ["key"]      Read a secret (or a key of a JSON secret) from Secrets Manager
+  ssm "name"               Read a parameter from Parameter Store, decrypting SecureStrings
+  env "NAME"               Read an environment variable
+  toJson VALUE             Encode a value as JSON
+  b64enc "text"            Encode a string as base64
+  indent N "text"          Indent every line of a string by N spaces
+
+Referencing a missing map key is an error.
+
+Example:
+
+database:
+  password: {{ decrypt "ENC[KMS,...]" | toJson }}
+  replica: {{ secman "app/db" "replica_password" | toJson }}
+  region: {{ env "AWS_REGION" }}
+tls:
+  cert: |
+{{ ssm "/app/tls/cert" | indent 4 }}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var input io.Reader = os.Stdin
+
+		fname, _ := cmd.Flags().GetString("template")
+		if fname != "" {
+			var ferr error
+			if input, ferr = os.Open(fname); ferr != nil {
+				panic(fmt.Errorf("unable to open file \"%s\": %v", fname, ferr))
+			}
+		}
+
+		awsRegion, err := cmd.Flags().GetString("aws-region")
+		if err != nil {
+			panic(err)
+		}
+
+		if err = processRender(input, os.Stdout, fname, awsRegion); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+
+	renderCmd.Flags().StringP("template", "t", "", "The template file to render, standard in is used by default")
+	renderCmd.Flags().String("aws-region", getFirstEnv("AWS_REGION", "AWS_DEFAULT_REGION"), "Provides the AWS region to use for Secrets Manager and Parameter Store lookups")
+}
+
+func processRender(in io.Reader, out io.Writer, name string, awsRegion string) error {
+	source, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("unable to read the template: %v", err)
+	}
+
+	if name == "" {
+		name = "<stdin>"
+	}
+
+	var tmpl *template.Template
+	if tmpl, err = template.New(name).Option("missingkey=error").Funcs(renderFuncs(awsRegion)).Parse(string(source)); err != nil {
+		return fmt.Errorf("unable to parse the template: %v", err)
+	}
+
+	// Render fully before writing so a failure never leaves partial output behind
+	buff := &bytes.Buffer{}
+	if err = tmpl.Execute(buff, nil); err != nil {
+		return fmt.Errorf("unable to render the template: %v", err)
+	}
+
+	_, err = out.Write(buff.Bytes())
+
+	return err
+}
+
+// renderFuncs returns the template helpers. AWS clients are only set up the first time they are used.
+func renderFuncs(awsRegion string) template.FuncMap {
+	var (
+		strategy cryptography.Decryptor
+		sm       *cryptography.SecretsManagerCryptoStrategy
+		ssm      *cryptography.SsmParameterStore
+	)
+
+	return template.FuncMap{
+		"decrypt": func(value string) (string, error) {
+			return decryptValue(value, &strategy)
+		},
+		"secman": func(secretId string, key ...string) (string, error) {
+			if len(key) > 1 {
+				return "", fmt.Errorf("secman takes a secret id and an optional key")
+			}
+
+			// Templates are committed like envelopes, so their lookups are held to the same policy
+			if err := decryptionPolicy.CheckSecretId(secretId); err != nil {
+				return "", err
+			}
+
+			if sm == nil {
+				var err error
+				if sm, err = newSecretsManagerStrategy(awsRegion); err != nil {
+					return "", fmt.Errorf("unable to create secrets manager crypto strategy: %v", err)
+				}
+			}
+
+			value, err := sm.GetSecret(secretId, strings.Join(key, ""))
+
+			return string(value), err
+		},
+		"ssm": func(name string) (string, error) {
+			if ssm == nil {
+				var err error
+				if ssm, err = cryptography.NewSsmParameterStore(awsRegion); err != nil {
+					return "", fmt.Errorf("unable to create the parameter store client: %v", err)
+				}
+			}
+
+			return ssm.GetParameter(name)
+		},
+		"env": os.Getenv,
+		"toJson": func(value interface{}) (string, error) {
+			buff := &bytes.Buffer{}
+			encoder := json.NewEncoder(buff)
+			encoder.SetEscapeHTML(false)
+
+			if err := encoder.Encode(value); err != nil {
+				return "", err
+			}
+
+			return strings.TrimSuffix(buff.String(), "\n"), nil
+		},
+		"b64enc": func(value string) string {
+			return base64.StdEncoding.EncodeToString([]byte(value))
+		},
+		"indent": func(spaces int, value string) string {
+			pad := strings.Repeat(" ", spaces)
+
+			return pad + strings.ReplaceAll(value, "\n", "\n"+pad)
+		},
+	}
+}
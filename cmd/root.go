@@ -3,12 +3,17 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/meltwater/dragoman/cryptography"
 	"github.com/spf13/cobra"
 )
 
 var VersionNumber string
 
+// referenceVariables expands placeholders in Secrets Manager references, set up from --var and --allow-var
+var referenceVariables *cryptography.ReferenceVariables
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "dragoman",
@@ -17,6 +22,26 @@ var rootCmd = &cobra.Command{
 and decrypt your secrets. A common use case is when your secrets need
 to live alongside your code.`,
 
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		patterns, err := cmd.Flags().GetStringSlice("envelope-syntax")
+		if err != nil {
+			return err
+		}
+
+		if err = configureSyntaxes(patterns); err != nil {
+			return err
+		}
+
+		vars, _ := cmd.Flags().GetStringArray("var")
+		allowed, _ := cmd.Flags().GetStringSlice("allow-var")
+
+		if referenceVariables, err = parseReferenceVariables(vars, allowed); err != nil {
+			return err
+		}
+
+		return configurePolicy(cmd)
+	},
+
 	Run: func(cmd *cobra.Command, args []string) {
 		vFlag, _ := cmd.Flags().GetBool("version")
 
@@ -46,4 +71,60 @@ func Execute() {
 
 func init() {
 	rootCmd.Flags().BoolP("version", "v", false, "Print the version number")
+
+	defaultSyntaxes := []string{cryptography.DefaultSyntax.String()}
+	if env := os.Getenv("DRAGOMAN_ENVELOPE_SYNTAX"); env != "" {
+		defaultSyntaxes = strings.Split(env, ",")
+	}
+
+	rootCmd.PersistentFlags().StringSlice("envelope-syntax", defaultSyntaxes, "The envelope syntaxes to recognise, \"...\" marks where the envelope goes. New envelopes use the first syntax. Defaults to $DRAGOMAN_ENVELOPE_SYNTAX")
+
+	defaultAllowed := []string{}
+	if env := os.Getenv("DRAGOMAN_ALLOWED_VARS"); env != "" {
+		defaultAllowed = strings.Split(env, ",")
+	}
+
+	rootCmd.PersistentFlags().StringArray("var", []string{}, "A NAME=value variable for ${NAME} placeholders in Secrets Manager references, can be repeated")
+	rootCmd.PersistentFlags().StringSlice("allow-var", defaultAllowed, "Environment variables that ${NAME} placeholders in Secrets Manager references may expand. Defaults to $DRAGOMAN_ALLOWED_VARS")
+
+	addPolicyFlags(rootCmd)
+}
+
+// configureSyntaxes parses syntax patterns such as "DRAGOMAN[...]" and makes them the recognised envelope syntaxes
+func configureSyntaxes(patterns []string) error {
+	list := make([]cryptography.Syntax, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		syntax, err := cryptography.ParseSyntax(strings.TrimSpace(pattern))
+		if err != nil {
+			return err
+		}
+
+		list = append(list, syntax)
+	}
+
+	return cryptography.SetSyntaxes(list)
+}
+
+// parseReferenceVariables reads NAME=value pairs and the names of environment variables allowed in references
+func parseReferenceVariables(vars []string, allowed []string) (*cryptography.ReferenceVariables, error) {
+	values := make(map[string]string, len(vars))
+
+	for _, pair := range vars {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid variable \"%s\", expected NAME=value", pair)
+		}
+
+		values[strings.TrimSpace(parts[0])] = parts[1]
+	}
+
+	names := make([]string, 0, len(allowed))
+	for _, name := range allowed {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return cryptography.NewReferenceVariables(names, values), nil
 }
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// rotateCmd represents the rotate command
+var rotateCmd = &cobra.Command{
+	Use:   "rotate file [file...]",
+	Short: "Re-encrypt the KMS envelopes in the provided files under a new KMS key",
+	Long: `Re-encrypt every ENC[KMS,...] value found in the provided files under a new KMS key.
+
+Each value is decrypted in memory and encrypted again with --to-kms-key-id, or the key
+of the first creation rule of .dragoman.yaml that matches the file. The
+files are rewritten in place, leaving everything other than the envelopes untouched.
+Envelopes that were wrapped over several lines are wrapped the same way again.
+
+Examples:
+
+Rotate every KMS envelope to a new key
+dragoman rotate --to-kms-key-id alias/new-key config/*.yaml
+
+Only rotate envelopes that were encrypted with the retired key
+dragoman rotate --from-key alias/old-key --to-kms-key-id alias/new-key config/prod.yaml
+
+Rotate every file to the key of its creation rule in .dragoman.yaml
+dragoman rotate envs/*/values.yaml`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var (
+			fromKey   string
+			awsRegion string
+			err       error
+		)
+
+		if fromKey, err = cmd.Flags().GetString("from-key"); err != nil {
+			panic(err)
+		}
+
+		if awsRegion, err = cmd.Flags().GetString("aws-region"); err != nil {
+			panic(err)
+		}
+
+		config, err := loadProjectConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		// Every file is rotated to --to-kms-key-id or the key of its creation rule in .dragoman.yaml
+		targets := map[string]rotateTarget{}
+		for _, fname := range args {
+			var target rotateTarget
+			if target.Key, target.Region, err = resolveKmsKey(cmd, "to-kms-key-id", config, fname); err != nil {
+				panic(err)
+			}
+
+			if target.Key == "" {
+				panic(fmt.Errorf("a destination key for \"%s\" must be provided with --to-kms-key-id or a creation rule in %s", fname, projectConfigName))
+			}
+
+			targets[fname] = target
+		}
+
+		if err = processRotate(&rotateConfig{
+			Files:     args,
+			Log:       os.Stderr,
+			FromKey:   fromKey,
+			AwsRegion: awsRegion,
+			Targets:   targets,
+			Policy:    decryptionPolicy,
+		}); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rotateCmd)
+
+	rotateCmd.Flags().String("to-kms-key-id", "", "Provides the KMS Key ID to re-encrypt with")
+	rotateCmd.Flags().String("from-key", "", "Only rotate envelopes encrypted with this KMS Key ID, ARN or alias")
+	rotateCmd.Flags().String("aws-region", getFirstEnv("AWS_REGION", "AWS_DEFAULT_REGION"), "Provides the AWS region to use for KMS")
+	addEnvironmentFlag(rotateCmd)
+}
+
+type rotateConfig struct {
+	Files     []string
+	Log       io.Writer
+	ToKey     string
+	FromKey   string
+	AwsRegion string
+	Targets   map[string]rotateTarget // Per file destination keys, overriding ToKey and AwsRegion
+	Policy    *cryptography.Policy    // Envelopes outside it are not rotated
+}
+
+// rotateTarget is the key, and the region of that key, a file is rotated to
+type rotateTarget struct {
+	Key    string
+	Region string
+}
+
+func processRotate(cfg *rotateConfig) error {
+	strategies := kmsStrategies{}
+	fromArns := map[string]string{} // --from-key resolved per region
+
+	for _, fname := range cfg.Files {
+		target, exists := cfg.Targets[fname]
+		if !exists {
+			target = rotateTarget{Key: cfg.ToKey, Region: cfg.AwsRegion}
+		}
+
+		// Resolve the filter so aliases and key ids can be compared with the key ARN the envelopes record. An alias
+		// is looked up in the region the file is rotated in, unless its ARN names another region.
+		var fromArn string
+		if cfg.FromKey != "" {
+			region := target.Region
+			if keyRegion := cryptography.KmsRegion(cfg.FromKey); keyRegion != "" {
+				region = keyRegion
+			}
+
+			var resolved bool
+			if fromArn, resolved = fromArns[region]; !resolved {
+				strategy, err := strategies.forRegion(region)
+				if err != nil {
+					return err
+				}
+
+				if fromArn, err = strategy.ResolveKeyArn(cfg.FromKey); err != nil {
+					return err
+				}
+				fromArns[region] = fromArn
+			}
+		}
+
+		if err := rotateFile(fname, strategies, fromArn, target, cfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rotateFile(fname string, strategies kmsStrategies, fromArn string, target rotateTarget, cfg *rotateConfig) error {
+	info, err := os.Stat(fname)
+	if err != nil {
+		return fmt.Errorf("unable to open file \"%s\": %v", fname, err)
+	}
+
+	var contents []byte
+	if contents, err = os.ReadFile(fname); err != nil {
+		return fmt.Errorf("unable to read file \"%s\": %v", fname, err)
+	}
+
+	// Files whose key lives in another region are encrypted with a strategy for that region
+	encrypter, err := strategies.forRegion(target.Region)
+	if err != nil {
+		return err
+	}
+
+	rotated := 0
+	output, err := cryptography.ReplaceEnvelopes(string(contents), func(envelope cryptography.Envelope) (string, error) {
+		if envelope.Type != cryptography.CRYPTO_KEY_KMS {
+			return envelope.Raw, nil
+		}
+
+		// Envelopes recording another key are left alone without being decrypted. Only older envelopes that do not
+		// record their key need decrypting to find it out.
+		inspected, err := cryptography.InspectEnvelope(envelope.Value())
+		if err != nil {
+			return "", fmt.Errorf("%s:%d:%d: %v", fname, envelope.Line, envelope.Column, err)
+		}
+
+		if fromArn != "" && inspected.KeyId != "" && inspected.KeyId != fromArn {
+			return envelope.Raw, nil
+		}
+
+		if err := cfg.Policy.CheckEnvelope(envelope.Value()); err != nil {
+			return "", fmt.Errorf("%s:%d:%d: %v", fname, envelope.Line, envelope.Column, err)
+		}
+
+		// The key being rotated away from may live in another region than the key rotated to
+		decrypter, err := strategies.forEnvelope(envelope.Value(), target.Region)
+		if err != nil {
+			return "", err
+		}
+
+		plaintext, keyArn, err := decrypter.WithPolicy(cfg.Policy).DecryptWithKeyId(envelope.Value())
+		if err != nil {
+			return "", fmt.Errorf("%s:%d:%d: %v", fname, envelope.Line, envelope.Column, err)
+		}
+
+		if fromArn != "" && keyArn != fromArn {
+			return envelope.Raw, nil
+		}
+
+		// The metadata describes the secret rather than the key, so it is kept apart from the key alias
+		metadata := movedMetadata(inspected.Metadata, nil)
+
+		replacement, err := encrypter.WithMetadata(metadata).Encrypt(plaintext, target.Key)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d:%d: error encountered attempting KMS encryption: %v", fname, envelope.Line, envelope.Column, err)
+		}
+
+		rotated++
+
+		return cryptography.RewrapEnvelope(envelope.Raw, replacement), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rotated > 0 {
+		if err = writeFileAtomic(fname, []byte(output), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(cfg.Log, "%s: rotated %d envelope(s)\n", fname, rotated)
+
+	return nil
+}
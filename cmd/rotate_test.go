@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// legacyKmsEnvelope encrypts the value the way dragoman did before envelopes recorded their key
+func legacyKmsEnvelope(t *testing.T, keyArn string, value string) string {
+	var nonce [24]byte
+	_, err := rand.Read(nonce[:])
+	assert.Nil(t, err)
+
+	var dataKey [32]byte
+	copy(dataKey[:], "some plaintext that is 32 bytes ")
+
+	payload := struct {
+		EncryptedDataKey []byte
+		Nonce            *[24]byte
+		Message          []byte
+	}{[]byte(keyArn), &nonce, secretbox.Seal(nil, []byte(value), &nonce, &dataKey)}
+
+	buff := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buff).Encode(payload))
+
+	return cryptography.WrapEncoding(cryptography.CRYPTO_KEY_KMS, buff.Bytes())
+}
+
+// decryptedKeys lists the keys whose data keys the client was asked to decrypt
+func decryptedKeys(client *kmsClientMock) []string {
+	keys := []string{}
+	for _, call := range client.Calls {
+		if call.Method == "Decrypt" {
+			keys = append(keys, string(call.Arguments.Get(1).(*kms.DecryptInput).CiphertextBlob))
+		}
+	}
+
+	return keys
+}
+
+func TestRotateFromKey(t *testing.T) {
+	const (
+		oldKey   = "arn:aws:kms:us-east-1:123456789012:key/old"
+		otherKey = "arn:aws:kms:us-east-1:123456789012:key/other"
+		newKey   = "arn:aws:kms:us-east-1:123456789012:key/new"
+	)
+
+	t.Run("it should only decrypt the envelopes of the key and those that do not record one", func(t *testing.T) {
+		mocks := mockAws(t)
+		client := mocks.kms("us-east-1").withKey("alias/old", oldKey).withKey(otherKey, otherKey).withKey(newKey, newKey)
+
+		other := kmsEnvelope(t, client, otherKey, "other", nil)
+		legacyOther := legacyKmsEnvelope(t, otherKey, "legacy other")
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("a: "+kmsEnvelope(t, client, "alias/old", "old", nil)+"\n"+
+			"b: "+other+"\n"+
+			"c: "+legacyKmsEnvelope(t, oldKey, "legacy old")+"\n"+
+			"d: "+legacyOther+"\n"), 0600))
+		client.Calls = nil
+
+		assert.Nil(t, processRotate(&rotateConfig{
+			Files:     []string{file},
+			Log:       &bytes.Buffer{},
+			ToKey:     newKey,
+			FromKey:   "alias/old",
+			AwsRegion: "us-east-1",
+		}))
+
+		// The envelope recording the other key is skipped offline, the legacy one has to be opened to find its key
+		assert.Equal(t, []string{oldKey, oldKey, otherKey}, decryptedKeys(client))
+
+		contents, err := os.ReadFile(file)
+		assert.Nil(t, err)
+
+		envelopes := cryptography.FindEnvelopes(string(contents))
+		assert.Len(t, envelopes, 4)
+
+		for i, expected := range []string{newKey, otherKey, newKey, ""} {
+			info, err := cryptography.InspectEnvelope(envelopes[i].Value())
+			assert.Nil(t, err)
+			assert.Equal(t, expected, info.KeyId, envelopes[i].Raw)
+		}
+
+		assert.Equal(t, other, envelopes[1].Raw)
+		assert.Equal(t, legacyOther, envelopes[3].Raw)
+	})
+
+	t.Run("it should look the key up in the region of the creation rule", func(t *testing.T) {
+		const (
+			euOldKey = "arn:aws:kms:eu-west-1:123456789012:key/old"
+			euNewKey = "arn:aws:kms:eu-west-1:123456789012:key/new"
+		)
+
+		mocks := mockAws(t)
+		client := mocks.kms("eu-west-1").withKey("alias/old", euOldKey).withKey("alias/new", euNewKey)
+
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		assert.Nil(t, os.WriteFile(file, []byte("a: "+kmsEnvelope(t, client, "alias/old", "old", nil)+"\n"+
+			"b: "+legacyKmsEnvelope(t, euOldKey, "legacy old")+"\n"), 0600))
+
+		assert.Nil(t, processRotate(&rotateConfig{
+			Files:     []string{file},
+			Log:       &bytes.Buffer{},
+			FromKey:   "alias/old",
+			AwsRegion: "us-east-1",
+			Targets:   map[string]rotateTarget{file: {Key: "alias/new", Region: "eu-west-1"}},
+		}))
+
+		// Nothing was looked up in --aws-region
+		assert.NotContains(t, mocks.kmsClients, "us-east-1")
+
+		contents, err := os.ReadFile(file)
+		assert.Nil(t, err)
+
+		envelopes := cryptography.FindEnvelopes(string(contents))
+		assert.Len(t, envelopes, 2)
+
+		for _, envelope := range envelopes {
+			info, err := cryptography.InspectEnvelope(envelope.Value())
+			assert.Nil(t, err)
+			assert.Equal(t, euNewKey, info.KeyId)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// smCmd groups the commands that manage secrets in AWS Secrets Manager
+var smCmd = &cobra.Command{
+	Use:   "sm",
+	Short: "Manage secrets in AWS Secrets Manager",
+}
+
+// smPutCmd represents the sm put command
+var smPutCmd = &cobra.Command{
+	Use:   "put",
+	Short: "Create or update a secret with the value read from standard in, printing its reference",
+	Long: `Store the value read from standard in in AWS Secrets Manager and print the
+ENC[SECMAN,...] reference to it, so secrets never have to be pasted into the AWS console.
+
+The secret is created when it does not exist. With --key the secret holds a JSON
+object and the value is stored under the key, keeping the other keys of the secret.
+
+Examples:
+
+Store a password under the password key of app/db
+printf '%s' "$PASSWORD" | dragoman sm put --name app/db --key password
+
+Replace the whole value of a secret
+dragoman sm put --name app/tls-key < tls.key`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		key, _ := cmd.Flags().GetString("key")
+		kmsKey, _ := cmd.Flags().GetString("kms-key-id")
+		awsRegion, _ := cmd.Flags().GetString("aws-region")
+
+		metadata, err := newEnvelopeMetadata(cmd, awsRegion)
+		if err != nil {
+			panic(err)
+		}
+
+		if err = processSmPut(&smPutConfig{
+			In:        os.Stdin,
+			Out:       os.Stdout,
+			Log:       os.Stderr,
+			Name:      name,
+			Key:       key,
+			KmsKey:    kmsKey,
+			AwsRegion: awsRegion,
+			Metadata:  metadata,
+		}); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(smCmd)
+	smCmd.AddCommand(smPutCmd)
+
+	smPutCmd.Flags().String("name", "", "The name or ARN of the secret")
+	smPutCmd.Flags().String("key", "", "Store the value under this key of a JSON secret, keeping its other keys")
+	smPutCmd.Flags().String("kms-key-id", "", "The KMS key that protects the secret when it is created, aws/secretsmanager by default")
+	smPutCmd.Flags().String("aws-region", getFirstEnv("AWS_REGION", "AWS_DEFAULT_REGION"), "Provides the AWS region to use for Secrets Manager")
+	addMetadataFlags(smPutCmd)
+}
+
+type smPutConfig struct {
+	In        io.Reader
+	Out       io.Writer
+	Log       io.Writer
+	Name      string
+	Key       string
+	KmsKey    string
+	AwsRegion string
+	Metadata  *cryptography.EnvelopeMetadata // Recorded in the reference
+}
+
+func processSmPut(cfg *smPutConfig) error {
+	var input []byte
+	var err error
+
+	if cfg.Name == "" {
+		return fmt.Errorf("the name of the secret must be provided with --name")
+	}
+
+	if input, err = ioutil.ReadAll(cfg.In); err != nil {
+		return fmt.Errorf("unable to read input: %v", err)
+	}
+
+	if len(input) == 0 {
+		return fmt.Errorf("no value was provided on standard in")
+	}
+
+	if cfg.AwsRegion == "" {
+		return fmt.Errorf("an aws region must be provided for Secrets Manager")
+	}
+
+	var strategy *cryptography.SecretsManagerCryptoStrategy
+	if strategy, err = newSecretsManagerStrategy(cfg.AwsRegion); err != nil {
+		return fmt.Errorf("unable to create secrets manager crypto strategy: %v", err)
+	}
+
+	var created bool
+	if created, err = strategy.PutSecret(cfg.Name, cfg.Key, input, cfg.KmsKey); err != nil {
+		return err
+	}
+
+	if created {
+		fmt.Fprintf(cfg.Log, "created the secret \"%s\"\n", cfg.Name)
+	} else {
+		fmt.Fprintf(cfg.Log, "updated the secret \"%s\"\n", cfg.Name)
+	}
+
+	var envelope string
+	if envelope, err = strategy.WithMetadata(cfg.Metadata).Encrypt([]byte(cfg.Name), cfg.Key); err != nil {
+		return fmt.Errorf("error encountered attempting secrets manager encryption: %v", err)
+	}
+
+	cfg.Out.Write([]byte(envelope))
+	cfg.Out.Write([]byte("\n"))
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/spf13/cobra"
+)
+
+// terraformExternalCmd represents the terraform-external command
+var terraformExternalCmd = &cobra.Command{
+	Use:   "terraform-external",
+	Short: "Act as a Terraform external data source that decrypts the values of its query",
+	Long: `Implement the protocol of Terraform's external data source.
+
+The query object is read from standard in as JSON. Every value containing an
+envelope is decrypted and the result is written to standard out as a flat JSON
+object of strings. Values without envelopes are passed through unchanged. On
+failure the error is written to standard error and the command exits with a
+non-zero status, which Terraform reports as the data source's error.
+
+Example:
+
+data "external" "secrets" {
+  program = ["dragoman", "terraform-external"]
+
+  query = {
+    db_password = "ENC[KMS,...]"
+  }
+}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := processTerraformExternal(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(terraformExternalCmd)
+}
+
+func processTerraformExternal(in io.Reader, out io.Writer) error {
+	payload, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("unable to read the query: %v", err)
+	}
+
+	query := map[string]string{}
+	if err = json.Unmarshal(payload, &query); err != nil {
+		return fmt.Errorf("the query must be a JSON object of strings: %v", err)
+	}
+
+	var strategy cryptography.Decryptor
+	result := make(map[string]string, len(query))
+
+	for key, value := range query {
+		if result[key], err = decryptValue(value, &strategy); err != nil {
+			return fmt.Errorf("unable to decrypt \"%s\": %v", key, err)
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+
+	return encoder.Encode(result)
+}
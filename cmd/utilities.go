@@ -1,7 +1,22 @@
 package cmd
 
 import (
+	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/meltwater/dragoman/formats"
+)
+
+// The AWS backed strategies are created through these, so tests can swap in strategies with mocked clients
+var (
+	newKmsStrategy            = cryptography.NewKmsCryptoStrategy
+	newSecretsManagerStrategy = cryptography.NewSecretsManagerCryptoStrategy
 )
 
 func min(a, b int) int {
@@ -22,3 +37,228 @@ func getFirstEnv(vars ...string) string {
 
 	return ""
 }
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place,
+// so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-"); err != nil {
+		return fmt.Errorf("unable to create temporary file for \"%s\": %v", path, err)
+	}
+
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("unable to set permissions on \"%s\": %v", tmp.Name(), err)
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		return fmt.Errorf("unable to write \"%s\": %v", tmp.Name(), err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("unable to sync \"%s\": %v", tmp.Name(), err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to close \"%s\": %v", tmp.Name(), err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to replace \"%s\": %v", path, err)
+	}
+
+	return nil
+}
+
+// newDecryptionStrategy sets up a decryptor that can handle every supported envelope type.
+// The KMS and Secrets Manager clients are only created once an envelope of their type is decrypted.
+// Placeholders in Secrets Manager references are expanded with the --var and --allow-var variables, and
+// envelopes outside the --policy are rejected before AWS is called.
+func newDecryptionStrategy() *cryptography.WildcardDecryptionStrategy {
+	return cryptography.NewLazyWildcardDecryptionStrategy(map[string]cryptography.StrategyBuilder{
+		"KMS": func() (cryptography.Decryptor, error) {
+			strategy, err := newKmsStrategy("")
+			if err != nil {
+				return nil, err
+			}
+
+			return strategy.WithPolicy(decryptionPolicy), nil
+		},
+		"SECMAN": func() (cryptography.Decryptor, error) {
+			strategy, err := newSecretsManagerStrategy("")
+			if err != nil {
+				return nil, err
+			}
+
+			return strategy.WithReferenceVariables(referenceVariables).WithPolicy(decryptionPolicy), nil
+		},
+	}).WithPolicy(decryptionPolicy)
+}
+
+// kmsStrategies holds one KMS strategy per region, created the first time the region is needed
+type kmsStrategies map[string]*cryptography.KmsCryptoStrategy
+
+func (s kmsStrategies) forRegion(region string) (*cryptography.KmsCryptoStrategy, error) {
+	if strategy, exists := s[region]; exists {
+		return strategy, nil
+	}
+
+	strategy, err := newKmsStrategy(region)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kms crypto strategy: %v", err)
+	}
+	s[region] = strategy
+
+	return strategy, nil
+}
+
+// forEnvelope returns a strategy for the region of the key recorded in a KMS envelope, as a data key can only be
+// decrypted in the region of its key. Envelopes that do not record their key get a strategy for the fallback region.
+func (s kmsStrategies) forEnvelope(envelope string, fallback string) (*cryptography.KmsCryptoStrategy, error) {
+	region := fallback
+	if info, err := cryptography.InspectEnvelope(envelope); err == nil && cryptography.KmsRegion(info.KeyId) != "" {
+		region = cryptography.KmsRegion(info.KeyId)
+	}
+
+	return s.forRegion(region)
+}
+
+// decryptValue decrypts every envelope in the value, setting up the decryption strategy on first use.
+// Expired envelopes are decrypted with a warning.
+func decryptValue(value string, strategy *cryptography.Decryptor) (string, error) {
+	if *strategy == nil {
+		*strategy = newDecryptionStrategy()
+	}
+
+	return cryptography.DecryptEnvelopesWithOptions(value, *strategy, cryptography.DecryptOptions{
+		OnExpired: cryptography.WarnExpired,
+		Warn:      expiryWarning(""),
+	})
+}
+
+// expiryWarning reports expired envelopes on standard error, located in fname when it is known
+func expiryWarning(fname string) func(cryptography.Envelope, error) {
+	return func(envelope cryptography.Envelope, err error) {
+		if fname == "" {
+			fmt.Fprintf(os.Stderr, "warning: %s: %v\n", envelope.Type, err)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "warning: %s:%d:%d: %s: %v\n", fname, envelope.Line, envelope.Column, envelope.Type, err)
+	}
+}
+
+// strategyFilter works out which envelope types to skip from --only and --skip lists of types
+func strategyFilter(only []string, skip []string) ([]string, error) {
+	known := []string{"KMS", "SECMAN"}
+
+	for _, key := range append(append([]string{}, only...), skip...) {
+		if !containsFold(known, key) {
+			return nil, fmt.Errorf("unknown envelope type \"%s\", expected one of %s", key, strings.Join(known, ", "))
+		}
+	}
+
+	skipped := []string{}
+	for _, key := range known {
+		if (len(only) > 0 && !containsFold(only, key)) || containsFold(skip, key) {
+			skipped = append(skipped, key)
+		}
+	}
+
+	return skipped, nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expandGlobs resolves file glob patterns into a sorted list of unique files.
+// On top of the usual glob syntax, a "**" path segment matches any number of directories.
+func expandGlobs(patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	files := []string{}
+
+	for _, pattern := range patterns {
+		var matches []string
+		var err error
+
+		if matches, err = globFiles(pattern); err != nil {
+			return nil, err
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match \"%s\"", pattern)
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+func globFiles(pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(filepath.FromSlash(pattern))
+	}
+
+	// Walk from the deepest directory that does not contain any glob syntax
+	segments := strings.Split(pattern, "/")
+	root := []string{}
+	for _, segment := range segments {
+		if strings.ContainsAny(segment, "*?[\\") {
+			break
+		}
+		root = append(root, segment)
+	}
+
+	base := strings.Join(root, "/")
+	if base == "" {
+		base = "."
+		if strings.HasPrefix(pattern, "/") {
+			base = "/"
+		}
+	}
+
+	matches := []string{}
+	err := filepath.WalkDir(filepath.FromSlash(base), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && matchGlob(pattern, filepath.ToSlash(p)) {
+			matches = append(matches, p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for \"%s\": %v", pattern, err)
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// matchGlob reports whether the slash separated name matches the pattern, where "**" matches zero or more path segments
+func matchGlob(pattern string, name string) bool {
+	return formats.MatchSegments(strings.Split(path.Clean(pattern), "/"), strings.Split(path.Clean(name), "/"))
+}
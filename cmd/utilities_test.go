@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/meltwater/dragoman/cryptography"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testKeyArn = "arn:aws:kms:us-east-1:123456789012:key/aKey"
+
+type kmsClientMock struct {
+	mock.Mock
+}
+
+func (m *kmsClientMock) GenerateDataKey(ctx context.Context, input *kms.GenerateDataKeyInput, opts ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*kms.GenerateDataKeyOutput), args.Error(1)
+}
+
+func (m *kmsClientMock) Decrypt(ctx context.Context, input *kms.DecryptInput, opts ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*kms.DecryptOutput), args.Error(1)
+}
+
+func (m *kmsClientMock) DescribeKey(ctx context.Context, input *kms.DescribeKeyInput, opts ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*kms.DescribeKeyOutput), args.Error(1)
+}
+
+// withKey makes the client generate and decrypt data keys of the key. The encrypted data key is the key ARN, so
+// several keys can be mocked on the same client.
+func (m *kmsClientMock) withKey(keyId string, keyArn string) *kmsClientMock {
+	dataKey := []byte("some plaintext that is 32 bytes ")
+
+	m.On("GenerateDataKey", context.TODO(), mock.MatchedBy(func(input *kms.GenerateDataKeyInput) bool {
+		return aws.ToString(input.KeyId) == keyId
+	}), mock.Anything).Return(&kms.GenerateDataKeyOutput{
+		KeyId:          aws.String(keyArn),
+		Plaintext:      dataKey,
+		CiphertextBlob: []byte(keyArn),
+	}, nil)
+
+	m.On("Decrypt", context.TODO(), mock.MatchedBy(func(input *kms.DecryptInput) bool {
+		return string(input.CiphertextBlob) == keyArn
+	}), mock.Anything).Return(&kms.DecryptOutput{
+		KeyId:     aws.String(keyArn),
+		Plaintext: dataKey,
+	}, nil)
+
+	m.On("DescribeKey", context.TODO(), mock.MatchedBy(func(input *kms.DescribeKeyInput) bool {
+		return aws.ToString(input.KeyId) == keyId
+	}), mock.Anything).Return(&kms.DescribeKeyOutput{
+		KeyMetadata: &kmstypes.KeyMetadata{Arn: aws.String(keyArn)},
+	}, nil).Maybe()
+
+	return m
+}
+
+type smClientMock struct {
+	mock.Mock
+}
+
+func (m *smClientMock) GetSecretValue(ctx context.Context, input *sm.GetSecretValueInput, opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*sm.GetSecretValueOutput), args.Error(1)
+}
+
+func (m *smClientMock) PutSecretValue(ctx context.Context, input *sm.PutSecretValueInput, opts ...func(*sm.Options)) (*sm.PutSecretValueOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*sm.PutSecretValueOutput), args.Error(1)
+}
+
+func (m *smClientMock) CreateSecret(ctx context.Context, input *sm.CreateSecretInput, opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*sm.CreateSecretOutput), args.Error(1)
+}
+
+// withSecret makes the client return the value of the secret
+func (m *smClientMock) withSecret(secretId string, value string) *smClientMock {
+	m.On("GetSecretValue", context.TODO(), mock.MatchedBy(func(input *sm.GetSecretValueInput) bool {
+		return aws.ToString(input.SecretId) == secretId
+	}), mock.Anything).Return(&sm.GetSecretValueOutput{SecretString: aws.String(value)}, nil)
+
+	return m
+}
+
+// withMissingSecrets makes the client report every secret not mocked so far as missing
+func (m *smClientMock) withMissingSecrets() *smClientMock {
+	m.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+		(*sm.GetSecretValueOutput)(nil), &smtypes.ResourceNotFoundException{})
+
+	return m
+}
+
+// awsMocks hands out one mocked KMS and Secrets Manager client per region
+type awsMocks struct {
+	kmsClients map[string]*kmsClientMock
+	smClients  map[string]*smClientMock
+}
+
+func (a *awsMocks) kms(region string) *kmsClientMock {
+	if _, exists := a.kmsClients[region]; !exists {
+		a.kmsClients[region] = new(kmsClientMock)
+	}
+
+	return a.kmsClients[region]
+}
+
+func (a *awsMocks) sm(region string) *smClientMock {
+	if _, exists := a.smClients[region]; !exists {
+		a.smClients[region] = new(smClientMock)
+	}
+
+	return a.smClients[region]
+}
+
+// mockAws makes the commands create their strategies with mocked clients for the duration of the test
+func mockAws(t *testing.T) *awsMocks {
+	mocks := &awsMocks{kmsClients: map[string]*kmsClientMock{}, smClients: map[string]*smClientMock{}}
+
+	newKms, newSm := newKmsStrategy, newSecretsManagerStrategy
+	t.Cleanup(func() {
+		newKmsStrategy, newSecretsManagerStrategy = newKms, newSm
+	})
+
+	newKmsStrategy = func(region string) (*cryptography.KmsCryptoStrategy, error) {
+		return cryptography.NewKmsCryptoStrategyWithClient(mocks.kms(region)), nil
+	}
+
+	newSecretsManagerStrategy = func(region string) (*cryptography.SecretsManagerCryptoStrategy, error) {
+		return cryptography.NewSecretsManagerCryptoStrategyWithClient(mocks.sm(region)), nil
+	}
+
+	return mocks
+}
+
+// kmsEnvelope encrypts the value with a KMS key mocked on the client
+func kmsEnvelope(t *testing.T, client *kmsClientMock, keyId string, value string, metadata *cryptography.EnvelopeMetadata) string {
+	envelope, err := cryptography.NewKmsCryptoStrategyWithClient(client).WithMetadata(metadata).Encrypt([]byte(value), keyId)
+	assert.Nil(t, err)
+
+	return envelope
+}
+
+// smEnvelope returns a reference to the secret
+func smEnvelope(t *testing.T, secretId string, metadata *cryptography.EnvelopeMetadata) string {
+	envelope, err := cryptography.NewSecretsManagerCryptoStrategyWithClient(nil).WithMetadata(metadata).Encrypt([]byte(secretId), "")
+	assert.Nil(t, err)
+
+	return envelope
+}
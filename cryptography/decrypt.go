@@ -0,0 +1,213 @@
+package cryptography
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EnvelopeError is the failure to decrypt a single envelope, along with where the envelope is
+type EnvelopeError struct {
+	Envelope Envelope
+	Err      error
+}
+
+func (e *EnvelopeError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s: %v", e.Envelope.Line, e.Envelope.Column, e.Envelope.Type, e.Err)
+}
+
+func (e *EnvelopeError) Unwrap() error {
+	return e.Err
+}
+
+// DecryptErrors collects the envelopes that could not be decrypted when decryption carries on after failures
+type DecryptErrors []*EnvelopeError
+
+func (e DecryptErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Sprintf("%d envelope(s) could not be decrypted: %s", len(e), strings.Join(messages, "; "))
+}
+
+// OnError decides what happens when an envelope cannot be decrypted
+type OnError string
+
+const (
+	// FailFast stops at the first envelope that cannot be decrypted
+	FailFast OnError = "fail"
+	// KeepFailed carries on and leaves the envelopes that cannot be decrypted as they are
+	KeepFailed OnError = "keep"
+	// MarkFailed carries on and replaces the envelopes that cannot be decrypted with a marker
+	MarkFailed OnError = "mark"
+)
+
+// DefaultFailureMarker replaces envelopes that cannot be decrypted in MarkFailed mode unless another marker is set
+const DefaultFailureMarker = "DECRYPTION_FAILED"
+
+// ParseOnError converts a user supplied mode name into an OnError
+func ParseOnError(name string) (OnError, error) {
+	switch mode := OnError(strings.ToLower(name)); mode {
+	case FailFast, KeepFailed, MarkFailed:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown error mode \"%s\", expected fail, keep or mark", name)
+}
+
+// OnExpired decides what happens to envelopes whose metadata says they have expired
+type OnExpired string
+
+const (
+	// AllowExpired decrypts expired envelopes like any other
+	AllowExpired OnExpired = "allow"
+	// WarnExpired decrypts expired envelopes and reports them through DecryptOptions.Warn
+	WarnExpired OnExpired = "warn"
+	// RefuseExpired treats expired envelopes as envelopes that cannot be decrypted
+	RefuseExpired OnExpired = "refuse"
+)
+
+// ParseOnExpired converts a user supplied mode name into an OnExpired
+func ParseOnExpired(name string) (OnExpired, error) {
+	switch mode := OnExpired(strings.ToLower(name)); mode {
+	case AllowExpired, WarnExpired, RefuseExpired:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown expiry mode \"%s\", expected allow, warn or refuse", name)
+}
+
+// ExpiredError reports an envelope that expired according to its metadata
+type ExpiredError struct {
+	Expires time.Time
+}
+
+func (e *ExpiredError) Error() string {
+	return fmt.Sprintf("the secret expired on %s", e.Expires.Format(time.RFC3339))
+}
+
+// DecryptOptions tunes DecryptEnvelopesWithOptions
+type DecryptOptions struct {
+	OnError OnError // Defaults to FailFast
+	Marker  string  // Written in place of failed envelopes in MarkFailed mode, defaults to DefaultFailureMarker
+
+	OnExpired OnExpired                          // Defaults to AllowExpired
+	Warn      func(envelope Envelope, err error) // Receives the expired envelopes in WarnExpired mode
+	Now       func() time.Time                   // The time expiry is checked against, defaults to time.Now
+
+	// Render turns the plaintext (or the marker) into the text that replaces the envelope, for example to escape it
+	// for the surrounding syntax. input is the full text being decrypted. The plaintext is used as it is when unset.
+	Render func(input string, envelope Envelope, plaintext string) (string, error)
+}
+
+// DecryptEnvelopesWithOptions decrypts every envelope in the input. Envelopes the strategy skips are left untouched.
+// In FailFast mode the first failure is returned as an *EnvelopeError. Otherwise every envelope is attempted and
+// the failures are returned as DecryptErrors, along with the output where failed envelopes were kept or marked.
+func DecryptEnvelopesWithOptions(input string, strategy Decryptor, options DecryptOptions) (string, error) {
+	if options.OnError == "" {
+		options.OnError = FailFast
+	}
+
+	if options.Marker == "" {
+		options.Marker = DefaultFailureMarker
+	}
+
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	render := options.Render
+	if render == nil {
+		render = func(_ string, _ Envelope, plaintext string) (string, error) { return plaintext, nil }
+	}
+
+	var failures DecryptErrors
+
+	output, err := ReplaceEnvelopes(input, func(envelope Envelope) (string, error) {
+		plaintext, err := decryptEnvelope(strategy, envelope)
+		if errors.Is(err, ErrSkipped) {
+			return envelope.Raw, nil
+		}
+
+		// Expiry is checked once decryption succeeded, which is when the metadata of KMS envelopes is authenticated
+		if err == nil {
+			err = checkExpiry(envelope, options)
+		}
+
+		var replacement string
+		if err == nil {
+			replacement, err = render(input, envelope, string(plaintext))
+		}
+
+		if err == nil {
+			return replacement, nil
+		}
+
+		failure := &EnvelopeError{Envelope: envelope, Err: err}
+		if options.OnError == FailFast {
+			return "", failure
+		}
+
+		failures = append(failures, failure)
+
+		if options.OnError == KeepFailed {
+			return envelope.Raw, nil
+		}
+
+		// The marker is rendered like a value so it cannot break the surrounding syntax either
+		if marker, err := render(input, envelope, options.Marker); err == nil {
+			return marker, nil
+		}
+
+		return options.Marker, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(failures) > 0 {
+		return output, failures
+	}
+
+	return output, nil
+}
+
+// checkExpiry applies the OnExpired mode to an envelope that was decrypted
+func checkExpiry(envelope Envelope, options DecryptOptions) error {
+	if options.OnExpired == "" || options.OnExpired == AllowExpired {
+		return nil
+	}
+
+	info, err := InspectEnvelope(envelope.Value())
+	if err != nil || !info.Metadata.Expired(options.Now()) {
+		return nil
+	}
+
+	expired := &ExpiredError{Expires: *info.Metadata.Expires}
+	if options.OnExpired == RefuseExpired {
+		return expired
+	}
+
+	if options.Warn != nil {
+		options.Warn(envelope, expired)
+	}
+
+	return nil
+}
+
+// decryptEnvelope decrypts a single envelope, turning any panic of the strategy into an error
+func decryptEnvelope(strategy Decryptor, envelope Envelope) (plaintext []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var ok bool
+			if err, ok = r.(error); !ok {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	return strategy.Decrypt(envelope.Value())
+}
@@ -0,0 +1,106 @@
+package cryptography
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDecryptor returns the base64 decoded payload, failing for "bad" and panicking for "panic"
+type fakeDecryptor struct{}
+
+func (fakeDecryptor) Key() string {
+	return "KMS"
+}
+
+func (fakeDecryptor) Decrypt(input string) ([]byte, error) {
+	message, err := UnwrapEncoding(input)
+	if err != nil {
+		return nil, err
+	}
+
+	switch string(message) {
+	case "bad":
+		return nil, fmt.Errorf("access denied")
+	case "panic":
+		panic(fmt.Errorf("it blew up"))
+	}
+
+	return message, nil
+}
+
+func TestDecryptEnvelopesWithOptions(t *testing.T) {
+	input := "a: " + WrapEncoding("KMS", []byte("one")) + "\n" +
+		"b: " + WrapEncoding("KMS", []byte("bad")) + "\n" +
+		"c: " + WrapEncoding("KMS", []byte("panic")) + "\n" +
+		"d: " + WrapEncoding("KMS", []byte("two")) + "\n"
+
+	t.Run("it should stop at the first failure by default", func(t *testing.T) {
+		output, err := DecryptEnvelopesWithOptions(input, fakeDecryptor{}, DecryptOptions{})
+
+		var envelopeErr *EnvelopeError
+		assert.True(t, errors.As(err, &envelopeErr))
+		assert.Equal(t, 2, envelopeErr.Envelope.Line)
+		assert.Equal(t, 4, envelopeErr.Envelope.Column)
+		assert.Equal(t, "KMS", envelopeErr.Envelope.Type)
+		assert.EqualError(t, envelopeErr.Unwrap(), "access denied")
+		assert.Equal(t, "", output)
+	})
+
+	t.Run("it should keep failed envelopes and report every failure", func(t *testing.T) {
+		output, err := DecryptEnvelopesWithOptions(input, fakeDecryptor{}, DecryptOptions{OnError: KeepFailed})
+
+		var failures DecryptErrors
+		assert.True(t, errors.As(err, &failures))
+		assert.Len(t, failures, 2)
+		assert.Equal(t, 2, failures[0].Envelope.Line)
+		assert.Equal(t, 3, failures[1].Envelope.Line)
+		assert.EqualError(t, failures[1].Err, "it blew up")
+
+		expected := "a: one\n" +
+			"b: " + WrapEncoding("KMS", []byte("bad")) + "\n" +
+			"c: " + WrapEncoding("KMS", []byte("panic")) + "\n" +
+			"d: two\n"
+		assert.Equal(t, expected, output)
+	})
+
+	t.Run("it should replace failed envelopes with the rendered marker", func(t *testing.T) {
+		output, err := DecryptEnvelopesWithOptions(input, fakeDecryptor{}, DecryptOptions{
+			OnError: MarkFailed,
+			Render: func(_ string, _ Envelope, plaintext string) (string, error) {
+				return "<" + plaintext + ">", nil
+			},
+		})
+
+		assert.Len(t, err, 2)
+		assert.Equal(t, "a: <one>\nb: <DECRYPTION_FAILED>\nc: <DECRYPTION_FAILED>\nd: <two>\n", output)
+	})
+
+	t.Run("it should report render failures against the envelope", func(t *testing.T) {
+		_, err := DecryptEnvelopesWithOptions(input, fakeDecryptor{}, DecryptOptions{
+			OnError: KeepFailed,
+			Marker:  "unused",
+			Render: func(_ string, _ Envelope, plaintext string) (string, error) {
+				if plaintext == "two" {
+					return "", fmt.Errorf("cannot escape")
+				}
+
+				return plaintext, nil
+			},
+		})
+
+		assert.Len(t, err, 3)
+		assert.Equal(t, 4, err.(DecryptErrors)[2].Envelope.Line)
+	})
+}
+
+func TestParseOnError(t *testing.T) {
+	mode, err := ParseOnError("Mark")
+	assert.Nil(t, err)
+	assert.Equal(t, MarkFailed, mode)
+
+	_, err = ParseOnError("ignore")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,163 @@
+package cryptography
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Envelope describes a single encrypted value found within a larger body of text
+type Envelope struct {
+	Type   string // The encryption strategy key (KMS, SECMAN, ...)
+	Raw    string // The envelope exactly as it appears in the input, including any line wrapping
+	Start  int    // Byte offset of the first character of the envelope
+	End    int    // Byte offset just past the last character of the envelope
+	Line   int    // 1-based line number of the first character
+	Column int    // 1-based column (in bytes) of the first character
+}
+
+// Value returns the envelope with any line wrapping removed, ready to be handed to a Decryptor
+func (e Envelope) Value() string {
+	return stripWhitespace(e.Raw)
+}
+
+// FindEnvelopes locates every envelope in the input in the order they appear
+func FindEnvelopes(input string) []Envelope {
+	matches := EnvelopeRegex.FindAllStringSubmatchIndex(input, -1)
+	envelopes := make([]Envelope, 0, len(matches))
+	positions := newPositionTracker(input)
+
+	for _, m := range matches {
+		line, column := positions.At(m[0])
+		groups := matchedGroups(m)
+
+		envelopes = append(envelopes, Envelope{
+			Type:   input[groups[0]:groups[1]],
+			Raw:    input[m[0]:m[1]],
+			Start:  m[0],
+			End:    m[1],
+			Line:   line,
+			Column: column,
+		})
+	}
+
+	return envelopes
+}
+
+// FindBrokenEnvelopes locates text that starts like an envelope (for example "ENC[KMS,") but is not a complete one,
+// which is usually the result of truncation or a bad copy and paste
+func FindBrokenEnvelopes(input string) []Envelope {
+	valid := FindEnvelopes(input)
+	broken := []Envelope{}
+	positions := newPositionTracker(input)
+
+	v := 0
+	for _, m := range envelopePrefixRegex.FindAllStringSubmatchIndex(input, -1) {
+		// Skip past any valid envelopes that end before this prefix
+		for v < len(valid) && valid[v].End <= m[0] {
+			v++
+		}
+
+		if v < len(valid) && valid[v].Start <= m[0] {
+			continue
+		}
+
+		line, column := positions.At(m[0])
+		groups := matchedGroups(m)
+
+		end := strings.IndexByte(input[m[0]:], '\n')
+		if end < 0 {
+			end = len(input)
+		} else {
+			end += m[0]
+		}
+
+		broken = append(broken, Envelope{
+			Type:   input[groups[0]:groups[1]],
+			Raw:    input[m[0]:end],
+			Start:  m[0],
+			End:    end,
+			Line:   line,
+			Column: column,
+		})
+	}
+
+	return broken
+}
+
+// ReplaceEnvelopes calls fn for every envelope in the input and substitutes the envelope with the returned string.
+// Processing stops at the first error.
+func ReplaceEnvelopes(input string, fn func(Envelope) (string, error)) (string, error) {
+	var sb strings.Builder
+	last := 0
+
+	for _, envelope := range FindEnvelopes(input) {
+		replacement, err := fn(envelope)
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString(input[last:envelope.Start])
+		sb.WriteString(replacement)
+		last = envelope.End
+	}
+
+	sb.WriteString(input[last:])
+
+	return sb.String(), nil
+}
+
+// RewrapEnvelope lays out a replacement envelope the same way the original was wrapped.
+// If the original spans several lines, the replacement is split at the width of the original's first line
+// and joined with the same whitespace (newline plus any indentation) the original used.
+func RewrapEnvelope(original string, replacement string) string {
+	width := strings.IndexFunc(original, unicode.IsSpace)
+	if width <= 0 {
+		return replacement
+	}
+
+	sepEnd := width + strings.IndexFunc(original[width:], func(r rune) bool { return !unicode.IsSpace(r) })
+	if sepEnd < width {
+		return replacement
+	}
+	separator := original[width:sepEnd]
+
+	var sb strings.Builder
+	for i := 0; i < len(replacement); i += width {
+		if i > 0 {
+			sb.WriteString(separator)
+		}
+
+		end := i + width
+		if end > len(replacement) {
+			end = len(replacement)
+		}
+		sb.WriteString(replacement[i:end])
+	}
+
+	return sb.String()
+}
+
+// positionTracker converts byte offsets into line and column numbers.
+// Offsets must be requested in increasing order so large inputs are only scanned once.
+type positionTracker struct {
+	input     string
+	line      int
+	lineStart int
+	scanned   int
+}
+
+func newPositionTracker(input string) *positionTracker {
+	return &positionTracker{input: input, line: 1}
+}
+
+// At returns the 1-based line and column of the byte at offset
+func (p *positionTracker) At(offset int) (int, int) {
+	for ; p.scanned < offset; p.scanned++ {
+		if p.input[p.scanned] == '\n' {
+			p.line++
+			p.lineStart = p.scanned + 1
+		}
+	}
+
+	return p.line, offset - p.lineStart + 1
+}
@@ -0,0 +1,86 @@
+package cryptography
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindEnvelopes(t *testing.T) {
+	t.Run("it should locate every envelope with its position", func(t *testing.T) {
+		input := "first: ENC[KMS,YWJj]\nsecond:\n  value: ENC[SECMAN,ZGVm]\n"
+
+		envelopes := FindEnvelopes(input)
+
+		assert.Len(t, envelopes, 2)
+		assert.Equal(t, "KMS", envelopes[0].Type)
+		assert.Equal(t, "ENC[KMS,YWJj]", envelopes[0].Raw)
+		assert.Equal(t, 1, envelopes[0].Line)
+		assert.Equal(t, 8, envelopes[0].Column)
+		assert.Equal(t, "SECMAN", envelopes[1].Type)
+		assert.Equal(t, 3, envelopes[1].Line)
+		assert.Equal(t, 10, envelopes[1].Column)
+		assert.Equal(t, "ENC[SECMAN,ZGVm]", input[envelopes[1].Start:envelopes[1].End])
+	})
+
+	t.Run("it should strip line wrapping from the value", func(t *testing.T) {
+		envelopes := FindEnvelopes("ENC[KMS,YW\n  Jj]")
+
+		assert.Len(t, envelopes, 1)
+		assert.Equal(t, "ENC[KMS,YW\n  Jj]", envelopes[0].Raw)
+		assert.Equal(t, "ENC[KMS,YWJj]", envelopes[0].Value())
+	})
+
+	t.Run("it should return nothing when there are no envelopes", func(t *testing.T) {
+		assert.Empty(t, FindEnvelopes("nothing to see here"))
+	})
+}
+
+func TestReplaceEnvelopes(t *testing.T) {
+	t.Run("it should replace each envelope and keep the surrounding text", func(t *testing.T) {
+		output, err := ReplaceEnvelopes("a=ENC[KMS,YWJj] b=ENC[SECMAN,ZGVm]", func(e Envelope) (string, error) {
+			return e.Type, nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "a=KMS b=SECMAN", output)
+	})
+
+	t.Run("it should stop on the first error", func(t *testing.T) {
+		output, err := ReplaceEnvelopes("a=ENC[KMS,YWJj]", func(e Envelope) (string, error) {
+			return "", fmt.Errorf("oopsie")
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, "", output)
+	})
+}
+
+func TestRewrapEnvelope(t *testing.T) {
+	t.Run("it should leave single line envelopes alone", func(t *testing.T) {
+		assert.Equal(t, "ENC[KMS,abcdefgh]", RewrapEnvelope("ENC[KMS,1234]", "ENC[KMS,abcdefgh]"))
+	})
+
+	t.Run("it should wrap at the original width with the original indentation", func(t *testing.T) {
+		original := "ENC[KM\n    S,12\n    34]"
+
+		assert.Equal(t, "ENC[KM\n    S,abcd\n    efgh]", RewrapEnvelope(original, "ENC[KMS,abcdefgh]"))
+	})
+}
+
+func TestFindBrokenEnvelopes(t *testing.T) {
+	t.Run("it should report envelopes that were never closed", func(t *testing.T) {
+		broken := FindBrokenEnvelopes("ok: ENC[KMS,YWJj]\nbad: ENC[SECMAN,ZGV!m]\n")
+
+		assert.Len(t, broken, 1)
+		assert.Equal(t, "SECMAN", broken[0].Type)
+		assert.Equal(t, 2, broken[0].Line)
+		assert.Equal(t, 6, broken[0].Column)
+		assert.Equal(t, "ENC[SECMAN,ZGV!m]", broken[0].Raw)
+	})
+
+	t.Run("it should ignore well formed and unrelated envelopes", func(t *testing.T) {
+		assert.Empty(t, FindBrokenEnvelopes("a: ENC[KMS,YW\n  Jj]\nb: ENC[PKCS7,abc]"))
+	})
+}
@@ -0,0 +1,51 @@
+package cryptography
+
+import "fmt"
+
+// EnvelopeInfo holds the details of an envelope that can be read without decrypting it
+type EnvelopeInfo struct {
+	Strategy      string `json:"strategy"`
+	Version       int    `json:"version"`
+	KeyId         string `json:"keyId,omitempty"`         // KMS key ARN, empty for envelopes created before it was recorded
+	SecretId      string `json:"secretId,omitempty"`      // Secrets Manager secret ARN or name
+	SecretKey     string `json:"secretKey,omitempty"`     // Secrets Manager key for key/value secrets
+	SecretVersion string `json:"secretVersion,omitempty"` // Secrets Manager version id the reference is pinned to
+	SecretStage   string `json:"secretStage,omitempty"`   // Secrets Manager version stage the reference is pinned to
+	Size          int    `json:"size"`                    // Size of the decoded envelope payload in bytes
+	PlaintextSize *int   `json:"plaintextSize,omitempty"`
+
+	// Metadata recorded when the envelope was created, nil for older envelopes. It is read offline, so
+	// it is only known to be authentic once a KMS envelope has been decrypted.
+	Metadata *EnvelopeMetadata `json:"metadata,omitempty"`
+}
+
+var inspectors = map[string]func([]byte) (*EnvelopeInfo, error){
+	CRYPTO_KEY_KMS: inspectKmsEnvelope,
+	CRYPTO_KEY_SM:  inspectSmEnvelope,
+}
+
+// InspectEnvelope decodes an envelope and reports what it contains. It never calls any AWS APIs.
+func InspectEnvelope(input string) (*EnvelopeInfo, error) {
+	etype := ExtractEncryptionType(input)
+
+	inspect, exists := inspectors[etype]
+	if !exists {
+		return nil, fmt.Errorf("unable to inspect ENC[%s,...] values", etype)
+	}
+
+	encrypted, err := UnwrapEncoding(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap the envelope: %v", err)
+	}
+
+	return inspect(encrypted)
+}
+
+// payloadVersion maps the zero value of unversioned payloads to the first format version
+func payloadVersion(version int) int {
+	if version == 0 {
+		return 1
+	}
+
+	return version
+}
@@ -0,0 +1,65 @@
+package cryptography
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestInspectEnvelope(t *testing.T) {
+	t.Run("it should report the key and plaintext size of a KMS envelope", func(t *testing.T) {
+		strategy, mockKms := getMockKmsStrategy()
+
+		mockKms.On("GenerateDataKey", context.TODO(), mock.Anything, mock.Anything).Return(&kms.GenerateDataKeyOutput{
+			KeyId:          aws.String("arn:aws:kms:us-east-1:123456789012:key/aKey"),
+			Plaintext:      []byte("some plaintext that is 32 bytes "),
+			CiphertextBlob: []byte("a CiphertextBlob"),
+		}, nil)
+
+		encrypted, _ := strategy.Encrypt([]byte("Jon Snow is a Targaryen"), "aKey")
+
+		info, err := InspectEnvelope(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, CRYPTO_KEY_KMS, info.Strategy)
+		assert.Equal(t, KMS_PAYLOAD_VERSION, info.Version)
+		assert.Equal(t, "arn:aws:kms:us-east-1:123456789012:key/aKey", info.KeyId)
+		assert.Equal(t, len("Jon Snow is a Targaryen"), *info.PlaintextSize)
+		mockKms.AssertNotCalled(t, "Decrypt", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should report the secret reference of a Secrets Manager envelope", func(t *testing.T) {
+		var encrypted string
+		generateMockSmEncryptedString("my-secret", "password", &encrypted)
+
+		info, err := InspectEnvelope(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, CRYPTO_KEY_SM, info.Strategy)
+		assert.Equal(t, SM_PAYLOAD_VERSION, info.Version)
+		assert.Equal(t, "my-secret", info.SecretId)
+		assert.Equal(t, "password", info.SecretKey)
+		assert.Nil(t, info.PlaintextSize)
+	})
+
+	t.Run("it should report the version a Secrets Manager envelope is pinned to", func(t *testing.T) {
+		strategy, _ := getMockSecretsManagerStrategy()
+		encrypted, _ := strategy.EncryptVersion([]byte("my-secret"), "", SecretVersion{Stage: "AWSPREVIOUS"})
+
+		info, err := InspectEnvelope(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "AWSPREVIOUS", info.SecretStage)
+		assert.Equal(t, "", info.SecretVersion)
+	})
+
+	t.Run("it should return an error for an envelope that cannot be decoded", func(t *testing.T) {
+		_, err := InspectEnvelope("ENC[KMS,bm90IGEgcGF5bG9hZA==]")
+
+		assert.Error(t, err)
+	})
+}
@@ -3,13 +3,17 @@ package cryptography
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"golang.org/x/crypto/nacl/secretbox"
 )
@@ -17,50 +21,221 @@ import (
 const (
 	KMS_DATA_KEY_LENGTH int32  = 32
 	CRYPTO_KEY_KMS      string = "KMS"
+	KMS_PAYLOAD_VERSION int    = 4
 )
 
+// kmsSealedMetadataMarker starts the plaintext sealed in version 4 envelopes, followed by a SHA-256 digest of the
+// envelope metadata and then the value. Since the marker is found after opening the message rather than by reading
+// the unauthenticated Version, metadata cannot be stripped by passing the envelope off as an older one.
+const kmsSealedMetadataMarker = "\x00dragoman sealed metadata\x00"
+
+const kmsSealedHeaderLength = len(kmsSealedMetadataMarker) + sha256.Size
+
 type kmsEnvelopeEncryptionPayload struct {
+	Version          int    // Zero for envelopes created before the payload was versioned
+	KeyId            string // ARN of the KMS key, recorded so envelopes can be inspected offline
 	EncryptedDataKey []byte
 	Nonce            *[24]byte
 	Message          []byte
+	Metadata         []byte // Encoded EnvelopeMetadata, empty when none was recorded
 }
 
-// kmsCryproClientIfc allows us to mock the kms client in tests
-type kmsCryptoClientIfc interface {
+// KmsClient is the part of the KMS API the strategy uses, it allows the client to be mocked in tests
+type KmsClient interface {
 	GenerateDataKey(context.Context, *kms.GenerateDataKeyInput, ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
 	Decrypt(context.Context, *kms.DecryptInput, ...func(*kms.Options)) (*kms.DecryptOutput, error)
+	DescribeKey(context.Context, *kms.DescribeKeyInput, ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
 }
 
 // KmsCryptoStrategy handles AWS KMS based encryption and decryption
 type KmsCryptoStrategy struct {
-	client kmsCryptoClientIfc
+	client        KmsClient
+	dataKeys      *kmsDataKeyCache  // Only set when data keys are reused, see WithDataKeyReuse
+	metadata      *EnvelopeMetadata // Recorded in new envelopes, see WithMetadata
+	policy        *Policy           // Checked against the key KMS reports on decrypt, see WithPolicy
+	deterministic bool              // Nonces are derived from the plaintext, see WithDeterministicNonces
 }
 
-func NewKmsCryptoStrategy(region string) (*KmsCryptoStrategy, error) {
-	// Do we need to set the region?
-	var cfg aws.Config
-	var err error
+// kmsDataKey is a generated data key along with its encrypted form and the ARN of the KMS key that protects it
+type kmsDataKey struct {
+	dataKey          *[32]byte
+	encryptedDataKey []byte
+	keyArn           string
+}
 
-	if region == "" {
-		if cfg, err = config.LoadDefaultConfig(context.TODO()); err != nil {
-			return nil, err
-		}
-	} else {
-		if cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion(region)); err != nil {
-			return nil, err
-		}
+type kmsDataKeyCache struct {
+	mutex sync.Mutex
+	keys  map[string]*kmsDataKey
+}
+
+func NewKmsCryptoStrategy(region string) (*KmsCryptoStrategy, error) {
+	cfg, err := loadAwsConfig(region)
+	if err != nil {
+		return nil, err
 	}
 
+	return NewKmsCryptoStrategyWithClient(kms.NewFromConfig(cfg)), nil
+}
+
+// NewKmsCryptoStrategyWithClient creates a strategy that calls KMS through the given client
+func NewKmsCryptoStrategyWithClient(client KmsClient) *KmsCryptoStrategy {
 	return &KmsCryptoStrategy{
-		client: kms.NewFromConfig(cfg),
-	}, nil
+		client: client,
+	}
 }
 
 func (cs KmsCryptoStrategy) Key() string {
 	return CRYPTO_KEY_KMS
 }
 
+// WithDataKeyReuse returns a strategy that generates one data key per KMS key id and seals every value encrypted
+// under that key id with it, making one KMS call instead of one per value. Every value still gets its own random
+// nonce, and the envelopes can be decrypted on their own as usual, they just carry the same encrypted data key.
+func (cs KmsCryptoStrategy) WithDataKeyReuse() *KmsCryptoStrategy {
+	return &KmsCryptoStrategy{
+		client:        cs.client,
+		dataKeys:      &kmsDataKeyCache{keys: make(map[string]*kmsDataKey)},
+		metadata:      cs.metadata,
+		policy:        cs.policy,
+		deterministic: cs.deterministic,
+	}
+}
+
+// WithDeterministicNonces returns a strategy that reuses data keys like WithDataKeyReuse and derives the nonce of
+// every value from the data key, the plaintext and the context it is encrypted with, instead of picking it at random.
+// Encrypting the same value in the same context with the same data key then gives the same envelope, which keeps
+// the diffs of regenerated files down to the values that changed, at the price of showing anyone who can read the
+// file which values did not change. See ReuseDataKey for picking up the data key of an earlier run.
+func (cs KmsCryptoStrategy) WithDeterministicNonces() *KmsCryptoStrategy {
+	reusing := cs.WithDataKeyReuse()
+	reusing.deterministic = true
+
+	return reusing
+}
+
+// WithMetadata returns a strategy that records the metadata in every envelope it creates.
+// The key alias is filled in from the key id when it is an alias.
+func (cs KmsCryptoStrategy) WithMetadata(metadata *EnvelopeMetadata) *KmsCryptoStrategy {
+	return &KmsCryptoStrategy{
+		client:        cs.client,
+		dataKeys:      cs.dataKeys,
+		metadata:      metadata,
+		policy:        cs.policy,
+		deterministic: cs.deterministic,
+	}
+}
+
+// WithPolicy returns a strategy that refuses to open envelopes whose data key KMS decrypted with a key outside
+// the policy. Unlike the key recorded in the envelope, the key reported by KMS cannot be forged.
+func (cs KmsCryptoStrategy) WithPolicy(policy *Policy) *KmsCryptoStrategy {
+	return &KmsCryptoStrategy{
+		client:        cs.client,
+		dataKeys:      cs.dataKeys,
+		metadata:      cs.metadata,
+		policy:        policy,
+		deterministic: cs.deterministic,
+	}
+}
+
+// dataKey generates a data key, or returns the one already generated for the key id when data keys are reused
+func (cs KmsCryptoStrategy) dataKey(keyId string) (*kmsDataKey, error) {
+	if cs.dataKeys != nil {
+		cs.dataKeys.mutex.Lock()
+		defer cs.dataKeys.mutex.Unlock()
+
+		if cached, exists := cs.dataKeys.keys[keyId]; exists {
+			return cached, nil
+		}
+	}
+
+	dataKey, encryptedDataKey, keyArn, err := cs.generateDataKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	generated := &kmsDataKey{dataKey: dataKey, encryptedDataKey: encryptedDataKey, keyArn: keyArn}
+	if cs.dataKeys != nil {
+		cs.dataKeys.keys[keyId] = generated
+	}
+
+	return generated, nil
+}
+
+// ReuseDataKey makes values encrypted under keyId use the data key of the first of the envelopes that was encrypted
+// with the same KMS key, so a file encrypted again keeps the data key of its previous version. It reports whether
+// such an envelope was found, when none was a new data key is generated as usual. Only strategies that reuse data
+// keys can be seeded.
+func (cs KmsCryptoStrategy) ReuseDataKey(keyId string, envelopes []string) (bool, error) {
+	if cs.dataKeys == nil {
+		return false, fmt.Errorf("the strategy does not reuse data keys")
+	}
+
+	// Aliases are only resolved once there is an envelope to compare the key with
+	keyArn := ""
+	if strings.HasPrefix(keyId, "arn:") && strings.Contains(keyId, ":key/") {
+		keyArn = keyId
+	}
+
+	for _, envelope := range envelopes {
+		if ExtractEncryptionType(envelope) != CRYPTO_KEY_KMS {
+			continue
+		}
+
+		encrypted, err := UnwrapEncoding(envelope)
+		if err != nil {
+			continue
+		}
+
+		var payload kmsEnvelopeEncryptionPayload
+		if err = gob.NewDecoder(bytes.NewReader(encrypted)).Decode(&payload); err != nil || payload.KeyId == "" {
+			continue
+		}
+
+		if keyArn == "" {
+			if keyArn, err = cs.ResolveKeyArn(keyId); err != nil {
+				return false, err
+			}
+		}
+
+		if payload.KeyId != keyArn {
+			continue
+		}
+
+		resp, err := cs.client.Decrypt(context.TODO(), &kms.DecryptInput{
+			CiphertextBlob: payload.EncryptedDataKey,
+		})
+		if err != nil {
+			return false, fmt.Errorf("unable to decipher the kms key of an existing envelope: %v", err)
+		}
+
+		// The recorded key id is not authenticated, only reuse data keys KMS confirms belong to the key
+		if aws.ToString(resp.KeyId) != keyArn {
+			return false, fmt.Errorf("the data key of an existing envelope belongs to \"%s\", not \"%s\"", aws.ToString(resp.KeyId), keyArn)
+		}
+
+		dataKey, err := AsNaCLKey(resp.Plaintext)
+		if err != nil {
+			return false, fmt.Errorf("unable to read kms key: %v", err)
+		}
+
+		cs.dataKeys.mutex.Lock()
+		cs.dataKeys.keys[keyId] = &kmsDataKey{dataKey: dataKey, encryptedDataKey: payload.EncryptedDataKey, keyArn: keyArn}
+		cs.dataKeys.mutex.Unlock()
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
 func (cs *KmsCryptoStrategy) GenerateDataKey(keyId string) (*[32]byte, []byte, error) {
+	dataKey, encryptedDataKey, _, err := cs.generateDataKey(keyId)
+
+	return dataKey, encryptedDataKey, err
+}
+
+// generateDataKey also returns the ARN of the key KMS used, which GenerateDataKey has always discarded
+func (cs *KmsCryptoStrategy) generateDataKey(keyId string) (*[32]byte, []byte, string, error) {
 	// Use KMS to generate a data key
 	var resp *kms.GenerateDataKeyOutput
 	var err error
@@ -69,47 +244,64 @@ func (cs *KmsCryptoStrategy) GenerateDataKey(keyId string) (*[32]byte, []byte, e
 		KeyId:         &keyId,
 		NumberOfBytes: aws.Int32(KMS_DATA_KEY_LENGTH),
 	}); err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
 
 	// Convert from byte slice to byte array
 	var dataKey *[32]byte
 	if dataKey, err = AsNaCLKey(resp.Plaintext); err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
 
-	return dataKey, resp.CiphertextBlob, nil
+	return dataKey, resp.CiphertextBlob, aws.ToString(resp.KeyId), nil
 }
 
 func (cs KmsCryptoStrategy) Encrypt(payload []byte, key string) (string, error) {
+	return cs.EncryptWithContext(payload, key, "")
+}
+
+// EncryptWithContext encrypts like Encrypt. With deterministic nonces the context, such as the path of the value
+// in its file, is mixed into the nonce so equal values in different places still get different envelopes.
+// The context is not stored and is not needed to decrypt.
+func (cs KmsCryptoStrategy) EncryptWithContext(payload []byte, key string, valueContext string) (string, error) {
 	var (
-		dataKey          *[32]byte
-		encryptedDataKey []byte
-		err              error
+		dataKey *kmsDataKey
+		err     error
 	)
 
 	// Use KMS to generate the data key
-	if dataKey, encryptedDataKey, err = cs.GenerateDataKey(key); err != nil {
+	if dataKey, err = cs.dataKey(key); err != nil {
 		return "", err
 	}
 
 	// Initialize the payload for the envelope
 	envelopePayload := &kmsEnvelopeEncryptionPayload{
-		EncryptedDataKey: encryptedDataKey,
+		Version:          KMS_PAYLOAD_VERSION,
+		KeyId:            dataKey.keyArn,
+		EncryptedDataKey: dataKey.encryptedDataKey,
 		Nonce:            &[24]byte{},
 	}
 
-	// Generate the nonce
-	if _, err = io.ReadFull(rand.Reader, envelopePayload.Nonce[:]); err != nil {
+	// Record the metadata, authenticated by sealing its digest along with the value
+	if envelopePayload.Metadata, err = encodeMetadata(cs.metadata.forKey(key)); err != nil {
+		return "", err
+	}
+
+	sealed := sealMetadataDigest(envelopePayload.Metadata, payload)
+
+	// Generate the nonce, deterministic nonces cover the metadata too so they never repeat for different messages
+	if cs.deterministic {
+		envelopePayload.Nonce = deterministicNonce(dataKey.dataKey, valueContext, sealed)
+	} else if _, err = io.ReadFull(rand.Reader, envelopePayload.Nonce[:]); err != nil {
 		return "", fmt.Errorf("failed to generate random nonce: %v", err)
 	}
 
 	// Seal the envelope
 	envelopePayload.Message = secretbox.Seal(
 		envelopePayload.Message,
-		payload,
+		sealed,
 		envelopePayload.Nonce,
-		dataKey)
+		dataKey.dataKey)
 
 	buff := &bytes.Buffer{}
 	if err = gob.NewEncoder(buff).Encode(envelopePayload); err != nil {
@@ -120,15 +312,27 @@ func (cs KmsCryptoStrategy) Encrypt(payload []byte, key string) (string, error)
 }
 
 func (cs KmsCryptoStrategy) Decrypt(input string) ([]byte, error) {
+	plaintext, _, err := cs.DecryptWithKeyId(input)
+
+	return plaintext, err
+}
+
+// DecryptWithKeyId decrypts the envelope and also reports the ARN of the KMS key that protected it
+func (cs KmsCryptoStrategy) DecryptWithKeyId(input string) ([]byte, string, error) {
 	encrypted, err := UnwrapEncoding(input)
 	if err != nil {
-		return nil, fmt.Errorf("unable to unwrap the encrypted secret: %v", err)
+		return nil, "", fmt.Errorf("unable to unwrap the encrypted secret: %v", err)
 	}
 
 	// Decode the payload struct
 	var payload kmsEnvelopeEncryptionPayload
 	if err = gob.NewDecoder(bytes.NewReader(encrypted)).Decode(&payload); err != nil {
-		return nil, fmt.Errorf("failed to decode the message payload: %v", err)
+		return nil, "", fmt.Errorf("failed to decode the message payload: %v", err)
+	}
+
+	// Version 3 authenticated its metadata differently and was never released
+	if payload.Version == 3 || payload.Version > KMS_PAYLOAD_VERSION {
+		return nil, "", fmt.Errorf("unsupported envelope version %d", payload.Version)
 	}
 
 	// Decrypt the key
@@ -138,21 +342,149 @@ func (cs KmsCryptoStrategy) Decrypt(input string) ([]byte, error) {
 		&kms.DecryptInput{
 			CiphertextBlob: payload.EncryptedDataKey,
 		}); err != nil {
-		return nil, fmt.Errorf("unable to decipher the kms key: %v", err)
+		return nil, "", fmt.Errorf("unable to decipher the kms key: %v", err)
+	}
+
+	if err = cs.policy.CheckKmsKey(aws.ToString(resp.KeyId)); err != nil {
+		return nil, "", err
 	}
 
 	// Convert the key to the expected NaCL type
 	var key *[32]byte
 	if key, err = AsNaCLKey(resp.Plaintext); err != nil {
-		return nil, fmt.Errorf("unable to read kms key: %v", err)
+		return nil, "", fmt.Errorf("unable to read kms key: %v", err)
 	}
 
 	// Decrypt the message
 	var plaintext []byte
 	var ok bool
 	if plaintext, ok = secretbox.Open(plaintext, payload.Message, payload.Nonce, key); !ok {
-		return nil, fmt.Errorf("failed to open the envelope")
+		return nil, "", fmt.Errorf("failed to open the envelope")
+	}
+
+	if plaintext, err = openMetadataDigest(&payload, plaintext); err != nil {
+		return nil, "", err
+	}
+
+	return plaintext, aws.ToString(resp.KeyId), nil
+}
+
+// sealMetadataDigest prefixes the value with the marker and the digest of the metadata, ready to be sealed
+func sealMetadataDigest(metadata []byte, payload []byte) []byte {
+	digest := sha256.Sum256(metadata)
+
+	sealed := make([]byte, 0, kmsSealedHeaderLength+len(payload))
+	sealed = append(sealed, kmsSealedMetadataMarker...)
+	sealed = append(sealed, digest[:]...)
+
+	return append(sealed, payload...)
+}
+
+// openMetadataDigest checks the metadata of an opened envelope and returns the value. Every envelope that sealed
+// a digest is checked against it, whatever version it claims to be. Only envelopes of the versions from before
+// metadata was recorded may come without one.
+func openMetadataDigest(payload *kmsEnvelopeEncryptionPayload, plaintext []byte) ([]byte, error) {
+	if bytes.HasPrefix(plaintext, []byte(kmsSealedMetadataMarker)) && len(plaintext) >= kmsSealedHeaderLength {
+		digest := sha256.Sum256(payload.Metadata)
+		if !hmac.Equal(plaintext[len(kmsSealedMetadataMarker):kmsSealedHeaderLength], digest[:]) {
+			return nil, fmt.Errorf("the envelope metadata has been tampered with")
+		}
+
+		return plaintext[kmsSealedHeaderLength:], nil
+	}
+
+	if payload.Version >= 4 || len(payload.Metadata) > 0 {
+		return nil, fmt.Errorf("the envelope metadata has been tampered with")
 	}
 
 	return plaintext, nil
 }
+
+// deterministicNonce derives a synthetic nonce from the context and the plaintext, keyed with a key derived from
+// the data key. Different plaintexts get different nonces, so a nonce is never used for two messages, while
+// nobody without the data key can tell which plaintext a nonce belongs to.
+func deterministicNonce(dataKey *[32]byte, valueContext string, payload []byte) *[24]byte {
+	derive := hmac.New(sha256.New, dataKey[:])
+	derive.Write([]byte("dragoman deterministic nonce"))
+
+	// The length prefix keeps the context and the plaintext apart
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(valueContext)))
+
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write(length)
+	mac.Write([]byte(valueContext))
+	mac.Write(payload)
+
+	nonce := &[24]byte{}
+	copy(nonce[:], mac.Sum(nil))
+
+	return nonce
+}
+
+// inspectKmsEnvelope reads the details of a KMS envelope payload without contacting KMS
+func inspectKmsEnvelope(encrypted []byte) (*EnvelopeInfo, error) {
+	var payload kmsEnvelopeEncryptionPayload
+	if err := gob.NewDecoder(bytes.NewReader(encrypted)).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("failed to decode the message payload: %v", err)
+	}
+
+	if len(payload.EncryptedDataKey) == 0 {
+		return nil, fmt.Errorf("the envelope is missing its encrypted data key")
+	}
+
+	if payload.Nonce == nil {
+		return nil, fmt.Errorf("the envelope is missing its nonce")
+	}
+
+	if len(payload.Message) < secretbox.Overhead {
+		return nil, fmt.Errorf("the encrypted message is truncated")
+	}
+
+	// From version 4 on the sealed plaintext starts with the metadata digest. The version is not authenticated, so
+	// this is only an estimate for tampered envelopes, which fail to decrypt anyway.
+	plaintextSize := len(payload.Message) - secretbox.Overhead
+	if payload.Version >= 4 && plaintextSize >= kmsSealedHeaderLength {
+		plaintextSize -= kmsSealedHeaderLength
+	}
+
+	metadata, err := decodeMetadata(payload.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvelopeInfo{
+		Strategy:      CRYPTO_KEY_KMS,
+		Version:       payloadVersion(payload.Version),
+		KeyId:         payload.KeyId,
+		Size:          len(encrypted),
+		PlaintextSize: &plaintextSize,
+		Metadata:      metadata,
+	}, nil
+}
+
+// ResolveKeyArn turns any accepted form of key identifier (key id, key ARN, alias name or alias ARN) into the key ARN
+func (cs KmsCryptoStrategy) ResolveKeyArn(keyId string) (string, error) {
+	resp, err := cs.client.DescribeKey(context.TODO(), &kms.DescribeKeyInput{
+		KeyId: &keyId,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to describe kms key \"%s\": %v", keyId, err)
+	}
+
+	if resp.KeyMetadata == nil || resp.KeyMetadata.Arn == nil {
+		return "", fmt.Errorf("kms did not return an ARN for key \"%s\"", keyId)
+	}
+
+	return *resp.KeyMetadata.Arn, nil
+}
+
+// KmsRegion returns the region of a KMS key or alias ARN, empty for anything else
+func KmsRegion(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" || parts[2] != "kms" {
+		return ""
+	}
+
+	return parts[3]
+}
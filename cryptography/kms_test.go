@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -27,6 +28,12 @@ func (m *kmsClientMock) Decrypt(ctx context.Context, input *kms.DecryptInput, op
 	return args.Get(0).(*kms.DecryptOutput), args.Error(1)
 }
 
+func (m *kmsClientMock) DescribeKey(ctx context.Context, input *kms.DescribeKeyInput, opts ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*kms.DescribeKeyOutput), args.Error(1)
+}
+
 func getMockKmsStrategy() (strategy *KmsCryptoStrategy, kmsClient *kmsClientMock) {
 	kmsClient = new(kmsClientMock)
 	strategy = &KmsCryptoStrategy{
@@ -168,6 +175,160 @@ func TestKmsEncryption(t *testing.T) {
 	})
 }
 
+func TestKmsDataKeyReuse(t *testing.T) {
+	t.Run("it should generate one data key per key id and still use a fresh nonce per value", func(t *testing.T) {
+		strategy, mockKms := getMockKmsStrategy()
+		reusing := strategy.WithDataKeyReuse()
+
+		gdkOutput := &kms.GenerateDataKeyOutput{
+			Plaintext:      []byte("some plaintext that is 32 bytes "),
+			CiphertextBlob: []byte("a CiphertextBlob"),
+		}
+
+		mockKms.On("GenerateDataKey", context.TODO(), mock.Anything, mock.Anything).Return(gdkOutput, nil)
+		mockKms.On("Decrypt", context.TODO(), mock.Anything, mock.Anything).Return(&kms.DecryptOutput{Plaintext: gdkOutput.Plaintext}, nil)
+
+		first, err := reusing.Encrypt([]byte("first"), "aKey")
+		assert.Nil(t, err)
+		second, err := reusing.Encrypt([]byte("first"), "aKey")
+		assert.Nil(t, err)
+		_, err = reusing.Encrypt([]byte("other"), "anotherKey")
+		assert.Nil(t, err)
+
+		assert.NotEqual(t, first, second)
+		mockKms.AssertNumberOfCalls(t, "GenerateDataKey", 2)
+
+		decrypted, err := strategy.Decrypt(second)
+		assert.Nil(t, err)
+		assert.Equal(t, "first", string(decrypted))
+	})
+
+	t.Run("it should generate a data key per value by default", func(t *testing.T) {
+		strategy, mockKms := getMockKmsStrategy()
+
+		mockKms.On("GenerateDataKey", context.TODO(), mock.Anything, mock.Anything).Return(&kms.GenerateDataKeyOutput{
+			Plaintext:      []byte("some plaintext that is 32 bytes "),
+			CiphertextBlob: []byte("a CiphertextBlob"),
+		}, nil)
+
+		strategy.Encrypt([]byte("first"), "aKey")
+		strategy.Encrypt([]byte("second"), "aKey")
+
+		mockKms.AssertNumberOfCalls(t, "GenerateDataKey", 2)
+	})
+}
+
+func TestKmsDeterministicNonces(t *testing.T) {
+	keyArn := "arn:aws:kms:us-east-1:123456789012:key/aKey"
+
+	mockDeterministicKms := func() (*KmsCryptoStrategy, *kmsClientMock) {
+		strategy, mockKms := getMockKmsStrategy()
+
+		mockKms.On("GenerateDataKey", context.TODO(), mock.Anything, mock.Anything).Return(&kms.GenerateDataKeyOutput{
+			KeyId:          aws.String(keyArn),
+			Plaintext:      []byte("some plaintext that is 32 bytes "),
+			CiphertextBlob: []byte("a CiphertextBlob"),
+		}, nil)
+		mockKms.On("Decrypt", context.TODO(), mock.Anything, mock.Anything).Return(&kms.DecryptOutput{
+			KeyId:     aws.String(keyArn),
+			Plaintext: []byte("some plaintext that is 32 bytes "),
+		}, nil)
+
+		return strategy, mockKms
+	}
+
+	t.Run("it should give equal values in the same context equal envelopes", func(t *testing.T) {
+		strategy, _ := mockDeterministicKms()
+		deterministic := strategy.WithDeterministicNonces()
+
+		first, err := deterministic.EncryptWithContext([]byte("hunter2"), keyArn, "db.password")
+		assert.Nil(t, err)
+		again, _ := deterministic.EncryptWithContext([]byte("hunter2"), keyArn, "db.password")
+		elsewhere, _ := deterministic.EncryptWithContext([]byte("hunter2"), keyArn, "api.token")
+		changed, _ := deterministic.EncryptWithContext([]byte("hunter3"), keyArn, "db.password")
+
+		assert.Equal(t, first, again)
+		assert.NotEqual(t, first, elsewhere)
+		assert.NotEqual(t, first, changed)
+
+		decrypted, err := strategy.Decrypt(first)
+		assert.Nil(t, err)
+		assert.Equal(t, "hunter2", string(decrypted))
+	})
+
+	t.Run("it should keep the context and the value apart", func(t *testing.T) {
+		dataKey := &[32]byte{}
+
+		assert.NotEqual(t, deterministicNonce(dataKey, "a", []byte("bc")), deterministicNonce(dataKey, "ab", []byte("c")))
+	})
+
+	t.Run("it should give the same envelopes again when reusing the data key of an earlier run", func(t *testing.T) {
+		strategy, _ := mockDeterministicKms()
+		earlier, _ := strategy.WithDeterministicNonces().EncryptWithContext([]byte("hunter2"), keyArn, "db.password")
+
+		strategy, mockKms := mockDeterministicKms()
+		deterministic := strategy.WithDeterministicNonces()
+
+		found, err := deterministic.ReuseDataKey(keyArn, []string{"ENC[SECMAN,abc]", earlier})
+		assert.Nil(t, err)
+		assert.True(t, found)
+
+		again, err := deterministic.EncryptWithContext([]byte("hunter2"), keyArn, "db.password")
+		assert.Nil(t, err)
+		assert.Equal(t, earlier, again)
+		mockKms.AssertNotCalled(t, "GenerateDataKey", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should resolve aliases to find the envelopes of their key", func(t *testing.T) {
+		strategy, _ := mockDeterministicKms()
+		earlier, _ := strategy.WithDeterministicNonces().Encrypt([]byte("hunter2"), keyArn)
+
+		strategy, mockKms := mockDeterministicKms()
+		alias := "alias/app"
+		mockKms.On("DescribeKey", context.TODO(), &kms.DescribeKeyInput{KeyId: &alias}, mock.Anything).Return(
+			&kms.DescribeKeyOutput{KeyMetadata: &types.KeyMetadata{Arn: aws.String(keyArn)}}, nil)
+
+		found, err := strategy.WithDeterministicNonces().ReuseDataKey(alias, []string{earlier})
+
+		assert.Nil(t, err)
+		assert.True(t, found)
+	})
+
+	t.Run("it should not reuse the data key of another key", func(t *testing.T) {
+		strategy, _ := mockDeterministicKms()
+		earlier, _ := strategy.WithDeterministicNonces().Encrypt([]byte("hunter2"), keyArn)
+
+		strategy, mockKms := mockDeterministicKms()
+		found, err := strategy.WithDeterministicNonces().ReuseDataKey("arn:aws:kms:us-east-1:123456789012:key/otherKey", []string{earlier})
+
+		assert.Nil(t, err)
+		assert.False(t, found)
+		mockKms.AssertNotCalled(t, "Decrypt", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should refuse data keys KMS does not attribute to the recorded key", func(t *testing.T) {
+		forged := kmsEnvelopeWithKeyId(keyArn)
+
+		strategy, mockKms := getMockKmsStrategy()
+		mockKms.On("Decrypt", context.TODO(), mock.Anything, mock.Anything).Return(&kms.DecryptOutput{
+			KeyId:     aws.String("arn:aws:kms:us-east-1:123456789012:key/otherKey"),
+			Plaintext: []byte("some plaintext that is 32 bytes "),
+		}, nil)
+
+		_, err := strategy.WithDeterministicNonces().ReuseDataKey(keyArn, []string{forged})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("it should only seed strategies that reuse data keys", func(t *testing.T) {
+		strategy, _ := getMockKmsStrategy()
+
+		_, err := strategy.ReuseDataKey(keyArn, nil)
+
+		assert.Error(t, err)
+	})
+}
+
 func generateMockEncryptedString(key string, secret string, output *string) {
 	strategy, mockKms := getMockKmsStrategy()
 
@@ -230,3 +391,70 @@ func TestKmsDecryption(t *testing.T) {
 		assert.Len(t, decrypted, 0)
 	})
 }
+
+func TestKmsDecryptWithKeyId(t *testing.T) {
+	t.Run("it should report the ARN of the key that protected the envelope", func(t *testing.T) {
+		superSecret := "Jon Snow is a Targaryen"
+		var encrypted string
+		generateMockEncryptedString("aKey", superSecret, &encrypted)
+
+		strategy, mockKms := getMockKmsStrategy()
+
+		mockDecryptOutput := &kms.DecryptOutput{
+			KeyId:     aws.String("arn:aws:kms:us-east-1:123456789012:key/aKey"),
+			Plaintext: []byte("some plaintext that is 32 bytes "),
+		}
+
+		mockKms.On("Decrypt", context.TODO(), mock.Anything, mock.Anything).Return(mockDecryptOutput, nil)
+
+		decrypted, keyArn, err := strategy.DecryptWithKeyId(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, superSecret, string(decrypted))
+		assert.Equal(t, "arn:aws:kms:us-east-1:123456789012:key/aKey", keyArn)
+	})
+}
+
+func TestKmsResolveKeyArn(t *testing.T) {
+	t.Run("it should resolve an alias to the key ARN", func(t *testing.T) {
+		strategy, mockKms := getMockKmsStrategy()
+
+		alias := "alias/my-key"
+		mockKms.On("DescribeKey", context.TODO(), &kms.DescribeKeyInput{KeyId: &alias}, mock.Anything).Return(
+			&kms.DescribeKeyOutput{
+				KeyMetadata: &types.KeyMetadata{
+					Arn: aws.String("arn:aws:kms:us-east-1:123456789012:key/aKey"),
+				},
+			}, nil)
+
+		arn, err := strategy.ResolveKeyArn(alias)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "arn:aws:kms:us-east-1:123456789012:key/aKey", arn)
+	})
+
+	t.Run("it should return an error if the key cannot be described", func(t *testing.T) {
+		strategy, mockKms := getMockKmsStrategy()
+
+		mockKms.On("DescribeKey", context.TODO(), mock.Anything, mock.Anything).Return(&kms.DescribeKeyOutput{}, fmt.Errorf("not found"))
+
+		_, err := strategy.ResolveKeyArn("alias/missing")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestKmsRegion(t *testing.T) {
+	t.Run("it should read the region of key and alias ARNs", func(t *testing.T) {
+		assert.Equal(t, "eu-west-1", KmsRegion("arn:aws:kms:eu-west-1:123456789012:key/1234abcd"))
+		assert.Equal(t, "cn-north-1", KmsRegion("arn:aws-cn:kms:cn-north-1:123456789012:key/1234abcd"))
+		assert.Equal(t, "us-east-1", KmsRegion("arn:aws:kms:us-east-1:123456789012:alias/app"))
+	})
+
+	t.Run("it should return nothing for anything else", func(t *testing.T) {
+		assert.Equal(t, "", KmsRegion(""))
+		assert.Equal(t, "", KmsRegion("1234abcd"))
+		assert.Equal(t, "", KmsRegion("alias/app"))
+		assert.Equal(t, "", KmsRegion("arn:aws:secretsmanager:eu-west-1:123456789012:secret:app"))
+	})
+}
@@ -0,0 +1,84 @@
+package cryptography
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// EnvelopeMetadata records how and when an envelope was created. It is stored next to the encrypted value, so it
+// can be read offline. KMS envelopes authenticate it with their data key: changing it makes decryption fail.
+// Secrets Manager envelopes are plain references and carry it unauthenticated.
+type EnvelopeMetadata struct {
+	Created  time.Time  `json:"created"`
+	Creator  string     `json:"creator,omitempty"`  // ARN of the AWS identity that created the envelope
+	Expires  *time.Time `json:"expires,omitempty"`  // No expiry when nil
+	KeyAlias string     `json:"keyAlias,omitempty"` // KMS key alias the envelope was encrypted with, when one was used
+	Note     string     `json:"note,omitempty"`
+}
+
+// Expired reports whether the envelope expired at the given time
+func (m *EnvelopeMetadata) Expired(now time.Time) bool {
+	return m != nil && m.Expires != nil && !now.Before(*m.Expires)
+}
+
+// forKey returns a copy of the metadata that records key as the key alias when it is one
+func (m *EnvelopeMetadata) forKey(key string) *EnvelopeMetadata {
+	if m == nil {
+		return nil
+	}
+
+	copied := *m
+	if copied.KeyAlias == "" && (strings.HasPrefix(key, "alias/") || strings.Contains(key, ":alias/")) {
+		copied.KeyAlias = key
+	}
+
+	return &copied
+}
+
+func encodeMetadata(metadata *EnvelopeMetadata) ([]byte, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+
+	buff := &bytes.Buffer{}
+	if err := gob.NewEncoder(buff).Encode(metadata); err != nil {
+		return nil, fmt.Errorf("failed to encode the envelope metadata: %v", err)
+	}
+
+	return buff.Bytes(), nil
+}
+
+func decodeMetadata(encoded []byte) (*EnvelopeMetadata, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	var metadata EnvelopeMetadata
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode the envelope metadata: %v", err)
+	}
+
+	return &metadata, nil
+}
+
+// CallerIdentity returns the ARN of the AWS identity the credentials belong to
+func CallerIdentity(region string) (string, error) {
+	cfg, err := loadAwsConfig(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("unable to get the caller identity: %v", err)
+	}
+
+	return aws.ToString(resp.Arn), nil
+}
@@ -0,0 +1,224 @@
+package cryptography
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// constantDecryptor decrypts every envelope to the same value
+type constantDecryptor string
+
+func (constantDecryptor) Key() string {
+	return CRYPTO_KEY_SM
+}
+
+func (d constantDecryptor) Decrypt(input string) ([]byte, error) {
+	return []byte(d), nil
+}
+
+func encryptWithMetadata(t *testing.T, metadata *EnvelopeMetadata) string {
+	strategy, mockKms := getMockKmsStrategy()
+
+	mockKms.On("GenerateDataKey", context.TODO(), mock.Anything, mock.Anything).Return(&kms.GenerateDataKeyOutput{
+		KeyId:          aws.String("arn:aws:kms:us-east-1:123456789012:key/aKey"),
+		Plaintext:      []byte("some plaintext that is 32 bytes "),
+		CiphertextBlob: []byte("a CiphertextBlob"),
+	}, nil)
+
+	encrypted, err := strategy.WithMetadata(metadata).Encrypt([]byte("Jon Snow is a Targaryen"), "alias/app")
+	assert.Nil(t, err)
+
+	return encrypted
+}
+
+func decryptWithMockKms(encrypted string) ([]byte, error) {
+	strategy, mockKms := getMockKmsStrategy()
+
+	mockKms.On("Decrypt", context.TODO(), mock.Anything, mock.Anything).Return(&kms.DecryptOutput{
+		Plaintext: []byte("some plaintext that is 32 bytes "),
+	}, nil)
+
+	return strategy.Decrypt(encrypted)
+}
+
+// rewriteKmsPayload decodes the payload of a KMS envelope, lets edit change it and encodes it again
+func rewriteKmsPayload(t *testing.T, encrypted string, edit func(*kmsEnvelopeEncryptionPayload)) string {
+	raw, err := UnwrapEncoding(encrypted)
+	assert.Nil(t, err)
+
+	var payload kmsEnvelopeEncryptionPayload
+	assert.Nil(t, gob.NewDecoder(bytes.NewReader(raw)).Decode(&payload))
+
+	edit(&payload)
+
+	buff := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buff).Encode(&payload))
+
+	return WrapEncoding(CRYPTO_KEY_KMS, buff.Bytes())
+}
+
+func TestKmsEnvelopeMetadata(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires := time.Date(2026, 4, 2, 0, 0, 0, 0, time.UTC)
+	metadata := &EnvelopeMetadata{
+		Created: created,
+		Creator: "arn:aws:iam::123456789012:user/jon",
+		Expires: &expires,
+		Note:    "rotated quarterly",
+	}
+
+	t.Run("it should record the metadata and the key alias, readable offline", func(t *testing.T) {
+		encrypted := encryptWithMetadata(t, metadata)
+
+		info, err := InspectEnvelope(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &EnvelopeMetadata{
+			Created:  created,
+			Creator:  "arn:aws:iam::123456789012:user/jon",
+			Expires:  &expires,
+			KeyAlias: "alias/app",
+			Note:     "rotated quarterly",
+		}, info.Metadata)
+		assert.Equal(t, "", metadata.KeyAlias, "the metadata given to the strategy is not modified")
+
+		decrypted, err := decryptWithMockKms(encrypted)
+		assert.Nil(t, err)
+		assert.Equal(t, "Jon Snow is a Targaryen", string(decrypted))
+	})
+
+	t.Run("it should refuse to decrypt envelopes whose metadata was changed", func(t *testing.T) {
+		tampered := rewriteKmsPayload(t, encryptWithMetadata(t, metadata), func(payload *kmsEnvelopeEncryptionPayload) {
+			later := expires.AddDate(10, 0, 0)
+			payload.Metadata, _ = encodeMetadata(&EnvelopeMetadata{Created: created, Expires: &later})
+		})
+
+		_, err := decryptWithMockKms(tampered)
+
+		assert.EqualError(t, err, "the envelope metadata has been tampered with")
+	})
+
+	t.Run("it should refuse to decrypt envelopes whose metadata was stripped", func(t *testing.T) {
+		stripped := rewriteKmsPayload(t, encryptWithMetadata(t, metadata), func(payload *kmsEnvelopeEncryptionPayload) {
+			payload.Metadata = nil
+		})
+
+		_, err := decryptWithMockKms(stripped)
+
+		assert.EqualError(t, err, "the envelope metadata has been tampered with")
+	})
+
+	t.Run("it should refuse envelopes whose metadata was stripped by passing them off as an older version", func(t *testing.T) {
+		for _, version := range []int{0, 2} {
+			downgraded := rewriteKmsPayload(t, encryptWithMetadata(t, metadata), func(payload *kmsEnvelopeEncryptionPayload) {
+				payload.Version = version
+				payload.Metadata = nil
+			})
+
+			_, err := decryptWithMockKms(downgraded)
+
+			assert.EqualError(t, err, "the envelope metadata has been tampered with", "version %d", version)
+		}
+	})
+
+	t.Run("it should refuse envelopes relabelled as version 3", func(t *testing.T) {
+		relabelled := rewriteKmsPayload(t, encryptWithMetadata(t, metadata), func(payload *kmsEnvelopeEncryptionPayload) {
+			payload.Version = 3
+		})
+
+		_, err := decryptWithMockKms(relabelled)
+
+		assert.EqualError(t, err, "unsupported envelope version 3")
+	})
+
+	t.Run("it should not record metadata unless asked to", func(t *testing.T) {
+		encrypted := encryptWithMetadata(t, nil)
+
+		info, err := InspectEnvelope(encrypted)
+		assert.Nil(t, err)
+		assert.Nil(t, info.Metadata)
+
+		_, err = decryptWithMockKms(encrypted)
+		assert.Nil(t, err)
+	})
+}
+
+func TestSmEnvelopeMetadata(t *testing.T) {
+	t.Run("it should record the metadata in the reference", func(t *testing.T) {
+		strategy, _ := getMockSecretsManagerStrategy()
+		metadata := &EnvelopeMetadata{Created: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Note: "db"}
+
+		encrypted, err := strategy.WithMetadata(metadata).Encrypt([]byte("app/db"), "")
+		assert.Nil(t, err)
+
+		info, err := InspectEnvelope(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, metadata, info.Metadata)
+	})
+}
+
+func TestDecryptExpiredEnvelopes(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	past := now.AddDate(0, -1, 0)
+	future := now.AddDate(0, 1, 0)
+
+	strategy, _ := getMockSecretsManagerStrategy()
+	expired, _ := strategy.WithMetadata(&EnvelopeMetadata{Created: past, Expires: &past}).Encrypt([]byte("old"), "")
+	current, _ := strategy.WithMetadata(&EnvelopeMetadata{Created: past, Expires: &future}).Encrypt([]byte("new"), "")
+	input := "a: " + expired + "\nb: " + current + "\n"
+
+	t.Run("it should decrypt expired envelopes by default", func(t *testing.T) {
+		output, err := DecryptEnvelopesWithOptions(input, constantDecryptor("x"), DecryptOptions{Now: func() time.Time { return now }})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "a: x\nb: x\n", output)
+	})
+
+	t.Run("it should warn about expired envelopes", func(t *testing.T) {
+		warned := []int{}
+		output, err := DecryptEnvelopesWithOptions(input, constantDecryptor("x"), DecryptOptions{
+			OnExpired: WarnExpired,
+			Warn:      func(envelope Envelope, err error) { warned = append(warned, envelope.Line) },
+			Now:       func() time.Time { return now },
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "a: x\nb: x\n", output)
+		assert.Equal(t, []int{1}, warned)
+	})
+
+	t.Run("it should refuse expired envelopes like failures", func(t *testing.T) {
+		output, err := DecryptEnvelopesWithOptions(input, constantDecryptor("x"), DecryptOptions{
+			OnExpired: RefuseExpired,
+			OnError:   KeepFailed,
+			Now:       func() time.Time { return now },
+		})
+
+		assert.Equal(t, "a: "+expired+"\nb: x\n", output)
+		assert.EqualError(t, err.(DecryptErrors)[0].Err, "the secret expired on 2026-05-01T00:00:00Z")
+	})
+}
+
+func TestParseOnExpired(t *testing.T) {
+	t.Run("it should accept the known modes in any case", func(t *testing.T) {
+		mode, err := ParseOnExpired("Refuse")
+
+		assert.Nil(t, err)
+		assert.Equal(t, RefuseExpired, mode)
+	})
+
+	t.Run("it should reject unknown modes", func(t *testing.T) {
+		_, err := ParseOnExpired("ignore")
+
+		assert.Error(t, err)
+	})
+}
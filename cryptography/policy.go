@@ -0,0 +1,182 @@
+package cryptography
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Policy restricts the KMS keys and Secrets Manager secrets envelopes may use, so an envelope planted in a file
+// cannot make a deploy read a key or secret it was never meant to. Entries are exact values or patterns where "*"
+// matches any text. A type of envelope without entries is refused, while a lone "*" entry allows every envelope of
+// its type, including KMS envelopes that do not record their key. A nil Policy allows everything.
+type Policy struct {
+	// KmsKeys holds key ARNs, key ids, aliases ("alias/app" or alias ARNs) or ARN patterns. Aliases are turned into
+	// key ARNs with ResolveAlias the first time a key is checked. An alias names a different key in every region, so
+	// alias names are resolved in the region of the key being checked, and alias ARNs in their own region.
+	KmsKeys []string
+
+	// SecretIds holds secret names, ARNs or patterns such as "prod/app/*", checked after placeholders are expanded
+	SecretIds []string
+
+	// Variables expands placeholders in Secrets Manager references the way the Secrets Manager strategy will
+	Variables *ReferenceVariables
+
+	// ResolveAlias turns an alias into the ARN of its key in the region, only needed when KmsKeys holds aliases
+	ResolveAlias func(alias string, region string) (string, error)
+
+	mutex       sync.Mutex
+	keyPatterns map[string][]*regexp.Regexp // Compiled KmsKeys per region
+}
+
+// CheckEnvelope rejects an envelope that references a key or secret outside the policy, without calling AWS.
+// KMS envelopes that do not record their key cannot be checked and are rejected unless every key is allowed.
+func (p *Policy) CheckEnvelope(input string) error {
+	if p == nil {
+		return nil
+	}
+
+	etype := ExtractEncryptionType(input)
+	if etype != CRYPTO_KEY_KMS && etype != CRYPTO_KEY_SM {
+		return nil
+	}
+
+	entries := p.KmsKeys
+	if etype == CRYPTO_KEY_SM {
+		entries = p.SecretIds
+	}
+
+	switch {
+	case allowsAny(entries):
+		return nil
+	case len(entries) == 0:
+		return fmt.Errorf("ENC[%s,...] envelopes are not allowed by the policy", etype)
+	}
+
+	info, err := InspectEnvelope(input)
+	if err != nil {
+		return err
+	}
+
+	if etype == CRYPTO_KEY_KMS {
+		if info.KeyId == "" {
+			return fmt.Errorf("the envelope does not record its KMS key, so it cannot be checked against the policy. Rotating it records the key")
+		}
+
+		return p.CheckKmsKey(info.KeyId)
+	}
+
+	secretId, err := p.Variables.Expand(info.SecretId)
+	if err != nil {
+		return err
+	}
+
+	return p.CheckSecretId(secretId)
+}
+
+// CheckKmsKey rejects a key ARN outside the policy
+func (p *Policy) CheckKmsKey(keyArn string) error {
+	if p == nil || allowsAny(p.KmsKeys) {
+		return nil
+	}
+
+	patterns, err := p.compiledKeyPatterns(KmsRegion(keyArn))
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(keyArn) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("the KMS key \"%s\" is not allowed by the policy", keyArn)
+}
+
+// CheckSecretId rejects a secret id outside the policy
+func (p *Policy) CheckSecretId(secretId string) error {
+	if p == nil || allowsAny(p.SecretIds) {
+		return nil
+	}
+
+	for _, pattern := range p.SecretIds {
+		if policyPattern(pattern).MatchString(secretId) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("the secret \"%s\" is not allowed by the policy", secretId)
+}
+
+// compiledKeyPatterns returns the KmsKeys patterns for keys of the region, compiling them the first time
+func (p *Policy) compiledKeyPatterns(region string) ([]*regexp.Regexp, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if patterns, exists := p.keyPatterns[region]; exists {
+		return patterns, nil
+	}
+
+	patterns, err := p.compileKeyPatterns(region)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyPatterns == nil {
+		p.keyPatterns = map[string][]*regexp.Regexp{}
+	}
+	p.keyPatterns[region] = patterns
+
+	return patterns, nil
+}
+
+// compileKeyPatterns resolves aliases to key ARNs in the region and turns bare key ids into patterns matching their ARNs
+func (p *Policy) compileKeyPatterns(region string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+
+	for _, entry := range p.KmsKeys {
+		switch {
+		case strings.HasPrefix(entry, "alias/") || strings.Contains(entry, ":alias/"):
+			if p.ResolveAlias == nil {
+				return nil, fmt.Errorf("the policy allows the alias \"%s\" but aliases cannot be resolved", entry)
+			}
+
+			aliasRegion := region
+			if KmsRegion(entry) != "" {
+				aliasRegion = KmsRegion(entry)
+			}
+
+			arn, err := p.ResolveAlias(entry, aliasRegion)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve the policy alias \"%s\": %v", entry, err)
+			}
+
+			patterns = append(patterns, regexp.MustCompile("^"+regexp.QuoteMeta(arn)+"$"))
+		case !strings.ContainsAny(entry, ":/"):
+			id := strings.TrimPrefix(policyPattern(entry).String(), "^")
+			patterns = append(patterns, regexp.MustCompile("^arn:[^:]+:kms:[^:]*:[^:]*:key/"+id))
+		default:
+			patterns = append(patterns, policyPattern(entry))
+		}
+	}
+
+	return patterns, nil
+}
+
+// allowsAny reports whether the entries opt out of restricting their type with "*"
+func allowsAny(entries []string) bool {
+	for _, entry := range entries {
+		if entry == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// policyPattern compiles an entry where "*" matches any text, including slashes and colons
+func policyPattern(entry string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(entry), `\*`, ".*") + "$")
+}
@@ -0,0 +1,229 @@
+package cryptography
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const policyKeyArn = "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+// countingDecryptor counts the envelopes handed to it
+type countingDecryptor struct {
+	key   string
+	calls int
+}
+
+func (d *countingDecryptor) Key() string {
+	return d.key
+}
+
+func (d *countingDecryptor) Decrypt(input string) ([]byte, error) {
+	d.calls++
+	return []byte("plaintext"), nil
+}
+
+// kmsEnvelopeWithKeyId builds a KMS envelope that records keyId, which is enough to check it against a policy
+func kmsEnvelopeWithKeyId(keyId string) string {
+	buff := &bytes.Buffer{}
+	gob.NewEncoder(buff).Encode(&kmsEnvelopeEncryptionPayload{
+		Version:          KMS_PAYLOAD_VERSION,
+		KeyId:            keyId,
+		EncryptedDataKey: []byte("a CiphertextBlob"),
+		Nonce:            &[24]byte{},
+		Message:          make([]byte, 32),
+	})
+
+	return WrapEncoding(CRYPTO_KEY_KMS, buff.Bytes())
+}
+
+func TestPolicyCheckSecretId(t *testing.T) {
+	policy := &Policy{SecretIds: []string{"prod/app/*", "shared/db"}}
+
+	t.Run("it should allow exact names and patterns", func(t *testing.T) {
+		assert.Nil(t, policy.CheckSecretId("prod/app/db"))
+		assert.Nil(t, policy.CheckSecretId("prod/app/nested/db"))
+		assert.Nil(t, policy.CheckSecretId("shared/db"))
+	})
+
+	t.Run("it should reject anything else", func(t *testing.T) {
+		assert.EqualError(t, policy.CheckSecretId("prod/other/db"), `the secret "prod/other/db" is not allowed by the policy`)
+		assert.Error(t, policy.CheckSecretId("shared/db2"))
+	})
+
+	t.Run("it should reject every secret when none are listed", func(t *testing.T) {
+		assert.Error(t, (&Policy{KmsKeys: []string{policyKeyArn}}).CheckSecretId("prod/app/db"))
+	})
+
+	t.Run("it should allow everything without a policy", func(t *testing.T) {
+		var none *Policy
+
+		assert.Nil(t, none.CheckSecretId("anything"))
+		assert.Nil(t, none.CheckKmsKey(policyKeyArn))
+		assert.Nil(t, none.CheckEnvelope(kmsEnvelopeWithKeyId("")))
+	})
+}
+
+func TestPolicyCheckKmsKey(t *testing.T) {
+	t.Run("it should match ARNs, key ids and ARN patterns", func(t *testing.T) {
+		assert.Nil(t, (&Policy{KmsKeys: []string{policyKeyArn}}).CheckKmsKey(policyKeyArn))
+		assert.Nil(t, (&Policy{KmsKeys: []string{"1234abcd-12ab-34cd-56ef-1234567890ab"}}).CheckKmsKey(policyKeyArn))
+		assert.Nil(t, (&Policy{KmsKeys: []string{"arn:aws:kms:*:123456789012:key/*"}}).CheckKmsKey(policyKeyArn))
+
+		assert.EqualError(t, (&Policy{KmsKeys: []string{"arn:aws:kms:*:999999999999:key/*"}}).CheckKmsKey(policyKeyArn),
+			fmt.Sprintf(`the KMS key "%s" is not allowed by the policy`, policyKeyArn))
+		assert.Error(t, (&Policy{KmsKeys: []string{"1234abcd"}}).CheckKmsKey(policyKeyArn))
+	})
+
+	t.Run("it should reject every key when none are listed, unless \"*\" is", func(t *testing.T) {
+		assert.Error(t, (&Policy{SecretIds: []string{"prod/app/*"}}).CheckKmsKey(policyKeyArn))
+		assert.Nil(t, (&Policy{KmsKeys: []string{"*"}}).CheckKmsKey(policyKeyArn))
+	})
+
+	t.Run("it should resolve aliases once", func(t *testing.T) {
+		resolved := 0
+		policy := &Policy{
+			KmsKeys: []string{"alias/app"},
+			ResolveAlias: func(alias string, region string) (string, error) {
+				resolved++
+				return policyKeyArn, nil
+			},
+		}
+
+		assert.Nil(t, policy.CheckKmsKey(policyKeyArn))
+		assert.Error(t, policy.CheckKmsKey("arn:aws:kms:eu-west-1:123456789012:key/other"))
+		assert.Equal(t, 1, resolved)
+	})
+
+	t.Run("it should resolve alias names in the region of the key being checked", func(t *testing.T) {
+		usKeyArn := "arn:aws:kms:us-east-1:123456789012:key/5678efgh"
+
+		policy := &Policy{
+			KmsKeys: []string{"alias/app", "arn:aws:kms:ap-south-1:123456789012:alias/legacy"},
+			ResolveAlias: func(alias string, region string) (string, error) {
+				switch {
+				case alias == "alias/app" && region == "eu-west-1":
+					return policyKeyArn, nil
+				case alias == "alias/app" && region == "us-east-1":
+					return usKeyArn, nil
+				case region == "ap-south-1":
+					return "arn:aws:kms:ap-south-1:123456789012:key/legacy", nil
+				}
+
+				return "", fmt.Errorf("unexpected alias %s in %s", alias, region)
+			},
+		}
+
+		assert.Nil(t, policy.CheckKmsKey(policyKeyArn))
+		assert.Nil(t, policy.CheckKmsKey(usKeyArn))
+		assert.Nil(t, policy.CheckKmsKey("arn:aws:kms:ap-south-1:123456789012:key/legacy"))
+		assert.Error(t, policy.CheckKmsKey("arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"),
+			"the key alias/app names in eu-west-1 is not allowed in us-east-1")
+	})
+
+	t.Run("it should reject every key when an alias cannot be resolved", func(t *testing.T) {
+		policy := &Policy{
+			KmsKeys:      []string{"alias/app", policyKeyArn},
+			ResolveAlias: func(alias string, region string) (string, error) { return "", fmt.Errorf("AccessDeniedException") },
+		}
+
+		assert.EqualError(t, policy.CheckKmsKey(policyKeyArn), `unable to resolve the policy alias "alias/app": AccessDeniedException`)
+	})
+}
+
+func TestPolicyCheckEnvelope(t *testing.T) {
+	t.Run("it should check the key recorded in KMS envelopes", func(t *testing.T) {
+		policy := &Policy{KmsKeys: []string{policyKeyArn}}
+
+		assert.Nil(t, policy.CheckEnvelope(kmsEnvelopeWithKeyId(policyKeyArn)))
+		assert.Error(t, policy.CheckEnvelope(kmsEnvelopeWithKeyId("arn:aws:kms:eu-west-1:123456789012:key/other")))
+		assert.Error(t, policy.CheckEnvelope(kmsEnvelopeWithKeyId("")), "envelopes without a recorded key cannot be checked")
+	})
+
+	t.Run("it should check the expanded secret id of Secrets Manager envelopes", func(t *testing.T) {
+		strategy, _ := getMockSecretsManagerStrategy()
+		templated, _ := strategy.Encrypt([]byte("${DRAGOMAN_ENV}/app/db"), "")
+
+		policy := &Policy{
+			SecretIds: []string{"prod/app/*"},
+			Variables: NewReferenceVariables(nil, map[string]string{"DRAGOMAN_ENV": "prod"}),
+		}
+		assert.Nil(t, policy.CheckEnvelope(templated))
+
+		policy.Variables = NewReferenceVariables(nil, map[string]string{"DRAGOMAN_ENV": "staging"})
+		assert.EqualError(t, policy.CheckEnvelope(templated), `the secret "staging/app/db" is not allowed by the policy`)
+	})
+
+	t.Run("it should refuse the types it has no entries for", func(t *testing.T) {
+		sm, _ := getMockSecretsManagerStrategy()
+		reference, _ := sm.Encrypt([]byte("prod/app/db"), "")
+
+		assert.EqualError(t, (&Policy{SecretIds: []string{"prod/app/*"}}).CheckEnvelope(kmsEnvelopeWithKeyId(policyKeyArn)),
+			"ENC[KMS,...] envelopes are not allowed by the policy")
+		assert.EqualError(t, (&Policy{KmsKeys: []string{policyKeyArn}}).CheckEnvelope(reference),
+			"ENC[SECMAN,...] envelopes are not allowed by the policy")
+	})
+
+	t.Run("it should allow every envelope of a type listed as \"*\"", func(t *testing.T) {
+		sm, _ := getMockSecretsManagerStrategy()
+		reference, _ := sm.Encrypt([]byte("anything/at/all"), "")
+
+		assert.Nil(t, (&Policy{KmsKeys: []string{"*"}}).CheckEnvelope(kmsEnvelopeWithKeyId("")))
+		assert.Nil(t, (&Policy{SecretIds: []string{"*"}}).CheckEnvelope(reference))
+	})
+}
+
+func TestWildcardPolicy(t *testing.T) {
+	t.Run("it should reject envelopes outside the policy before calling the strategy", func(t *testing.T) {
+		sm, _ := getMockSecretsManagerStrategy()
+		allowed, _ := sm.Encrypt([]byte("prod/app/db"), "")
+		planted, _ := sm.Encrypt([]byte("prod/payments/db"), "")
+
+		decryptor := &countingDecryptor{key: CRYPTO_KEY_SM}
+		strategy := NewLazyWildcardDecryptionStrategy(nil).Add(CRYPTO_KEY_SM, decryptor).WithPolicy(&Policy{SecretIds: []string{"prod/app/*"}})
+
+		_, err := strategy.Decrypt(allowed)
+		assert.Nil(t, err)
+
+		_, err = strategy.Decrypt(planted)
+		assert.EqualError(t, err, `the secret "prod/payments/db" is not allowed by the policy`)
+
+		assert.Equal(t, 1, decryptor.calls)
+	})
+}
+
+func TestKmsPolicy(t *testing.T) {
+	t.Run("it should refuse envelopes whose data key belongs to a key outside the policy", func(t *testing.T) {
+		var encrypted string
+		generateMockEncryptedString("aKey", "Jon Snow is a Targaryen", &encrypted)
+
+		strategy, mockKms := getMockKmsStrategy()
+		mockKms.On("Decrypt", context.TODO(), mock.Anything, mock.Anything).Return(&kms.DecryptOutput{
+			KeyId:     aws.String("arn:aws:kms:eu-west-1:123456789012:key/other"),
+			Plaintext: []byte("some plaintext that is 32 bytes "),
+		}, nil)
+
+		_, err := strategy.WithPolicy(&Policy{KmsKeys: []string{policyKeyArn}}).Decrypt(encrypted)
+
+		assert.EqualError(t, err, `the KMS key "arn:aws:kms:eu-west-1:123456789012:key/other" is not allowed by the policy`)
+	})
+}
+
+func TestSmPolicy(t *testing.T) {
+	t.Run("it should refuse to read secrets outside the policy", func(t *testing.T) {
+		strategy, mockSm := getMockSecretsManagerStrategy()
+		planted, _ := strategy.Encrypt([]byte("prod/payments/db"), "")
+
+		_, err := strategy.WithPolicy(&Policy{SecretIds: []string{"prod/app/*"}}).Decrypt(planted)
+
+		assert.EqualError(t, err, `the secret "prod/payments/db" is not allowed by the policy`)
+		mockSm.AssertNotCalled(t, "GetSecretValue", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,95 @@
+package cryptography
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// referencePlaceholderRegex matches ${NAME} placeholders in Secrets Manager references
+var referencePlaceholderRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ReferenceVariables expands ${NAME} placeholders in the secret ids and keys of Secrets Manager envelopes, so one
+// committed file can point at different secrets per environment. Only allowed names are expanded, anything else is
+// an error, which keeps an envelope from pulling an arbitrary environment variable such as a credential into a
+// request. Values are taken from Values first, then from the environment.
+type ReferenceVariables struct {
+	allowed map[string]bool
+	values  map[string]string
+	lookup  func(string) (string, bool)
+}
+
+// NewReferenceVariables allows the names in allowed to be expanded from the environment. Names in values are
+// allowed as well, since they were provided on purpose.
+func NewReferenceVariables(allowed []string, values map[string]string) *ReferenceVariables {
+	vars := &ReferenceVariables{
+		allowed: make(map[string]bool),
+		values:  make(map[string]string),
+		lookup:  os.LookupEnv,
+	}
+
+	for _, name := range allowed {
+		vars.allowed[name] = true
+	}
+
+	for name, value := range values {
+		vars.allowed[name] = true
+		vars.values[name] = value
+	}
+
+	return vars
+}
+
+// Expand replaces the placeholders of a reference. A placeholder that is not allowed, or whose variable is unset or
+// empty, is an error, since a partially expanded reference would read the wrong secret. A nil ReferenceVariables
+// allows nothing, references without placeholders are returned as they are.
+func (v *ReferenceVariables) Expand(reference string) (string, error) {
+	var err error
+
+	expanded := referencePlaceholderRegex.ReplaceAllStringFunc(reference, func(placeholder string) string {
+		if err != nil {
+			return placeholder
+		}
+
+		name := referencePlaceholderRegex.FindStringSubmatch(placeholder)[1]
+
+		if v == nil || !v.allowed[name] {
+			err = fmt.Errorf("the variable \"%s\" in \"%s\" is not allowed to be expanded, allowed variables: %s", name, reference, v.describeAllowed())
+			return placeholder
+		}
+
+		value, ok := v.values[name]
+		if !ok {
+			value, _ = v.lookup(name)
+		}
+
+		if value == "" {
+			err = fmt.Errorf("the variable \"%s\" in \"%s\" is not set", name, reference)
+			return placeholder
+		}
+
+		return value
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return expanded, nil
+}
+
+func (v *ReferenceVariables) describeAllowed() string {
+	if v == nil || len(v.allowed) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(v.allowed))
+	for name := range v.allowed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,58 @@
+package cryptography
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferenceVariables(t *testing.T) {
+	env := map[string]string{"DRAGOMAN_ENV": "staging", "AWS_SECRET_ACCESS_KEY": "do-not-leak", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	newVariables := func(allowed []string, values map[string]string) *ReferenceVariables {
+		vars := NewReferenceVariables(allowed, values)
+		vars.lookup = lookup
+		return vars
+	}
+
+	t.Run("it should expand allowed environment variables", func(t *testing.T) {
+		expanded, err := newVariables([]string{"DRAGOMAN_ENV"}, nil).Expand("${DRAGOMAN_ENV}/db")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "staging/db", expanded)
+	})
+
+	t.Run("it should prefer provided values over the environment", func(t *testing.T) {
+		expanded, err := newVariables([]string{"DRAGOMAN_ENV"}, map[string]string{"DRAGOMAN_ENV": "prod", "REGION": "eu"}).Expand("${DRAGOMAN_ENV}/${REGION}/db")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "prod/eu/db", expanded)
+	})
+
+	t.Run("it should leave references without placeholders untouched", func(t *testing.T) {
+		var vars *ReferenceVariables
+
+		expanded, err := vars.Expand("prod/db$1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "prod/db$1", expanded)
+	})
+
+	t.Run("it should refuse variables that are not allowed", func(t *testing.T) {
+		_, err := newVariables([]string{"DRAGOMAN_ENV"}, nil).Expand("${AWS_SECRET_ACCESS_KEY}")
+
+		assert.EqualError(t, err, `the variable "AWS_SECRET_ACCESS_KEY" in "${AWS_SECRET_ACCESS_KEY}" is not allowed to be expanded, allowed variables: DRAGOMAN_ENV`)
+	})
+
+	t.Run("it should refuse unset and empty variables", func(t *testing.T) {
+		_, err := newVariables([]string{"MISSING"}, nil).Expand("${MISSING}/db")
+		assert.EqualError(t, err, `the variable "MISSING" in "${MISSING}/db" is not set`)
+
+		_, err = newVariables([]string{"EMPTY"}, nil).Expand("${EMPTY}/db")
+		assert.EqualError(t, err, `the variable "EMPTY" in "${EMPTY}/db" is not set`)
+	})
+}
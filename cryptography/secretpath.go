@@ -0,0 +1,194 @@
+package cryptography
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// secretPathSegment is an object key or an array index of a secret path
+type secretPathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func (s secretPathSegment) String() string {
+	if s.isIndex {
+		return fmt.Sprintf("[%d]", s.index)
+	}
+
+	return s.key
+}
+
+// parseSecretPath reads a dotted path with optional array indexes, for example "db.primary.password" or "hosts[0]".
+// A JSONPath style "$." prefix is accepted, and keys containing dots or brackets can be quoted as ['a.b'] or ["a.b"].
+func parseSecretPath(path string) ([]secretPathSegment, error) {
+	segments := []secretPathSegment{}
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "['") || strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest[2:], rest[1:2]+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted key in \"%s\"", path)
+			}
+
+			segments = append(segments, secretPathSegment{key: rest[2 : 2+end]})
+			rest = rest[2+end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in \"%s\"", path)
+			}
+
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index \"%s\" in \"%s\"", rest[1:end], path)
+			}
+
+			segments = append(segments, secretPathSegment{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in \"%s\"", path)
+			}
+
+			segments = append(segments, secretPathSegment{key: rest[:end]})
+			rest = rest[end:]
+		}
+
+		// Segments are separated by dots, indexes and quoted keys can follow directly
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return nil, fmt.Errorf("trailing dot in \"%s\"", path)
+			}
+		} else if rest != "" && rest[0] != '[' {
+			return nil, fmt.Errorf("unexpected \"%s\" in \"%s\"", rest, path)
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+
+	return segments, nil
+}
+
+// lookupSecretKey resolves a key or path in a JSON secret and returns the value as text.
+// A top level key that matches the whole path wins, so keys containing dots keep working.
+// Strings are returned as they are, other scalars as their JSON text and objects or arrays as compact JSON.
+// The errors complete a sentence starting with the secret, for example `the secret "x" has no key "y"`.
+func lookupSecretKey(secret []byte, path string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(secret))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("is not JSON, so it has no key \"%s\"", path)
+	}
+
+	if object, ok := document.(map[string]interface{}); ok {
+		if value, exists := object[path]; exists {
+			return stringifySecretValue(value)
+		}
+	}
+
+	segments, err := parseSecretPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot be read with the key \"%s\": %v", path, err)
+	}
+
+	current := document
+	for i, segment := range segments {
+		parent := joinSecretPath(segments[:i])
+
+		if segment.isIndex {
+			array, ok := current.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("has no item \"%s\", %s is not an array", joinSecretPath(segments[:i+1]), describeSecretPath(parent))
+			}
+
+			if segment.index >= len(array) {
+				return nil, fmt.Errorf("has no item \"%s\", %s has %d item(s)", joinSecretPath(segments[:i+1]), describeSecretPath(parent), len(array))
+			}
+
+			current = array[segment.index]
+			continue
+		}
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("has no key \"%s\", %s is not an object", joinSecretPath(segments[:i+1]), describeSecretPath(parent))
+		}
+
+		value, exists := object[segment.key]
+		if !exists {
+			keys := make([]string, 0, len(object))
+			for key := range object {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			where := ""
+			if parent != "" {
+				where = fmt.Sprintf(" at \"%s\"", parent)
+			}
+
+			return nil, fmt.Errorf("has no key \"%s\", available keys%s: %s", joinSecretPath(segments[:i+1]), where, strings.Join(keys, ", "))
+		}
+
+		current = value
+	}
+
+	return stringifySecretValue(current)
+}
+
+func stringifySecretValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case nil:
+		return []byte{}, nil
+	default:
+		buff := &bytes.Buffer{}
+		encoder := json.NewEncoder(buff)
+		encoder.SetEscapeHTML(false)
+
+		if err := encoder.Encode(v); err != nil {
+			return nil, err
+		}
+
+		return bytes.TrimSuffix(buff.Bytes(), []byte("\n")), nil
+	}
+}
+
+func joinSecretPath(segments []secretPathSegment) string {
+	var sb strings.Builder
+	for i, segment := range segments {
+		if i > 0 && !segment.isIndex {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(segment.String())
+	}
+
+	return sb.String()
+}
+
+func describeSecretPath(path string) string {
+	if path == "" {
+		return "it"
+	}
+
+	return fmt.Sprintf("\"%s\"", path)
+}
@@ -0,0 +1,75 @@
+package cryptography
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecretPath(t *testing.T) {
+	t.Run("it should read dotted keys, indexes and quoted keys", func(t *testing.T) {
+		segments, err := parseSecretPath(`$.db.hosts[1]['a.b']["c"].d`)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []secretPathSegment{
+			{key: "db"},
+			{key: "hosts"},
+			{index: 1, isIndex: true},
+			{key: "a.b"},
+			{key: "c"},
+			{key: "d"},
+		}, segments)
+	})
+
+	t.Run("it should reject malformed paths", func(t *testing.T) {
+		for _, path := range []string{"", "a..b", "a.", "a[x]", "a[1", "a['b", "a[-1]", "a[0]b"} {
+			_, err := parseSecretPath(path)
+			assert.Error(t, err, path)
+		}
+	})
+}
+
+func TestLookupSecretKey(t *testing.T) {
+	secret := []byte(`{
+		"password": "hunter2",
+		"port": 5432,
+		"enabled": true,
+		"nothing": null,
+		"db.legacy": "dotted",
+		"db": {"primary": {"password": "p@ss", "tags": ["a", "<b>"]}},
+		"hosts": ["one", "two"]
+	}`)
+
+	t.Run("it should stringify scalars and nested values", func(t *testing.T) {
+		for path, expected := range map[string]string{
+			"password":            "hunter2",
+			"port":                "5432",
+			"enabled":             "true",
+			"nothing":             "",
+			"db.legacy":           "dotted",
+			"db.primary.password": "p@ss",
+			"$.hosts[1]":          "two",
+			"db.primary.tags":     `["a","<b>"]`,
+			"db.primary":          `{"password":"p@ss","tags":["a","<b>"]}`,
+		} {
+			value, err := lookupSecretKey(secret, path)
+
+			assert.Nil(t, err, path)
+			assert.Equal(t, expected, string(value), path)
+		}
+	})
+
+	t.Run("it should fail loudly when the path does not resolve", func(t *testing.T) {
+		_, err := lookupSecretKey(secret, "db.primary.pasword")
+		assert.EqualError(t, err, `has no key "db.primary.pasword", available keys at "db.primary": password, tags`)
+
+		_, err = lookupSecretKey(secret, "hosts[2]")
+		assert.EqualError(t, err, `has no item "hosts[2]", "hosts" has 2 item(s)`)
+
+		_, err = lookupSecretKey(secret, "password.length")
+		assert.EqualError(t, err, `has no key "password.length", "password" is not an object`)
+
+		_, err = lookupSecretKey([]byte("plain text"), "password")
+		assert.EqualError(t, err, `is not JSON, so it has no key "password"`)
+	})
+}
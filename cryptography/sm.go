@@ -5,47 +5,110 @@ import (
 	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"unicode/utf8"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 )
 
 const (
-	CRYPTO_KEY_SM string = "SECMAN"
+	CRYPTO_KEY_SM      string = "SECMAN"
+	SM_PAYLOAD_VERSION int    = 3
 )
 
 type smEnvelopeEncryptionPayload struct {
-	SecretID  []byte // Secret ARN or Name
-	SecretKey []byte // Key for Secret Key/Value pairs
+	Version      int    // Zero for envelopes created before the payload was versioned
+	SecretID     []byte // Secret ARN or Name
+	SecretKey    []byte // Key for Secret Key/Value pairs
+	VersionId    []byte // Pinned secret version, empty to follow the version stage
+	VersionStage []byte // Pinned version stage, AWSCURRENT when both are empty
+	Metadata     []byte // Encoded EnvelopeMetadata, empty when none was recorded
 }
 
-type smCryptoClientIfc interface {
+// SecretVersion pins a secret to a version id or a version stage such as AWSPREVIOUS.
+// The zero value reads the AWSCURRENT version.
+type SecretVersion struct {
+	Id    string
+	Stage string
+}
+
+// input sets the version of a GetSecretValue request
+func (v SecretVersion) input(secretId string) *sm.GetSecretValueInput {
+	input := &sm.GetSecretValueInput{SecretId: &secretId}
+
+	if v.Id != "" {
+		input.VersionId = &v.Id
+	}
+
+	if v.Stage != "" {
+		input.VersionStage = &v.Stage
+	}
+
+	return input
+}
+
+// SecretsManagerClient is the part of the Secrets Manager API the strategy uses, it allows the client to be mocked in tests
+type SecretsManagerClient interface {
 	GetSecretValue(context.Context, *sm.GetSecretValueInput, ...func(*sm.Options)) (*sm.GetSecretValueOutput, error)
+	PutSecretValue(context.Context, *sm.PutSecretValueInput, ...func(*sm.Options)) (*sm.PutSecretValueOutput, error)
+	CreateSecret(context.Context, *sm.CreateSecretInput, ...func(*sm.Options)) (*sm.CreateSecretOutput, error)
 }
 
 type SecretsManagerCryptoStrategy struct {
-	client smCryptoClientIfc
+	client    SecretsManagerClient
+	variables *ReferenceVariables // Expands placeholders in references, see WithReferenceVariables
+	metadata  *EnvelopeMetadata   // Recorded in new envelopes, see WithMetadata
+	policy    *Policy             // Checked against the expanded secret id on decrypt, see WithPolicy
 }
 
 func NewSecretsManagerCryptoStrategy(region string) (*SecretsManagerCryptoStrategy, error) {
-	var cfg aws.Config
-	var err error
+	cfg, err := loadAwsConfig(region)
+	if err != nil {
+		return nil, err
+	}
 
-	if region == "" {
-		if cfg, err = config.LoadDefaultConfig(context.TODO()); err != nil {
-			return nil, err
-		}
-	} else {
-		if cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion(region)); err != nil {
-			return nil, err
-		}
+	return NewSecretsManagerCryptoStrategyWithClient(sm.NewFromConfig(cfg)), nil
+}
+
+// NewSecretsManagerCryptoStrategyWithClient creates a strategy that calls Secrets Manager through the given client
+func NewSecretsManagerCryptoStrategyWithClient(client SecretsManagerClient) *SecretsManagerCryptoStrategy {
+	return &SecretsManagerCryptoStrategy{
+		client: client,
 	}
+}
 
+// WithReferenceVariables returns a strategy that expands ${NAME} placeholders in the secret ids and keys of the
+// envelopes it decrypts. Without it any placeholder is an error.
+func (cs SecretsManagerCryptoStrategy) WithReferenceVariables(variables *ReferenceVariables) *SecretsManagerCryptoStrategy {
 	return &SecretsManagerCryptoStrategy{
-		client: sm.NewFromConfig(cfg),
-	}, nil
+		client:    cs.client,
+		variables: variables,
+		metadata:  cs.metadata,
+		policy:    cs.policy,
+	}
+}
+
+// WithMetadata returns a strategy that records the metadata in every envelope it creates. A reference holds no
+// key material, so unlike KMS envelopes the metadata is not authenticated.
+func (cs SecretsManagerCryptoStrategy) WithMetadata(metadata *EnvelopeMetadata) *SecretsManagerCryptoStrategy {
+	return &SecretsManagerCryptoStrategy{
+		client:    cs.client,
+		variables: cs.variables,
+		metadata:  metadata,
+		policy:    cs.policy,
+	}
+}
+
+// WithPolicy returns a strategy that refuses to read secrets outside the policy
+func (cs SecretsManagerCryptoStrategy) WithPolicy(policy *Policy) *SecretsManagerCryptoStrategy {
+	return &SecretsManagerCryptoStrategy{
+		client:    cs.client,
+		variables: cs.variables,
+		metadata:  cs.metadata,
+		policy:    policy,
+	}
 }
 
 func (cs SecretsManagerCryptoStrategy) Key() string {
@@ -57,9 +120,23 @@ func (cs SecretsManagerCryptoStrategy) Key() string {
 // 	@param: key is used for key/value pair keys
 // 	@returns: The base64 encoded arn with the encryption strategy key
 func (cs SecretsManagerCryptoStrategy) Encrypt(payload []byte, key string) (string, error) {
+	return cs.EncryptVersion(payload, key, SecretVersion{})
+}
+
+// EncryptVersion generates the wrapped encoded string of a reference pinned to a secret version
+func (cs SecretsManagerCryptoStrategy) EncryptVersion(payload []byte, key string, version SecretVersion) (string, error) {
+	metadata, err := encodeMetadata(cs.metadata)
+	if err != nil {
+		return "", err
+	}
+
 	envelopePayload := &smEnvelopeEncryptionPayload{
-		SecretID:  payload,
-		SecretKey: []byte(key),
+		Version:      SM_PAYLOAD_VERSION,
+		SecretID:     payload,
+		SecretKey:    []byte(key),
+		VersionId:    []byte(version.Id),
+		VersionStage: []byte(version.Stage),
+		Metadata:     metadata,
 	}
 
 	buff := &bytes.Buffer{}
@@ -84,29 +161,254 @@ func (cs SecretsManagerCryptoStrategy) Decrypt(input string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to decode the message payload: %v", err)
 	}
 
-	secretId := string(payload.SecretID)
+	// The secret id and key may hold placeholders such as ${DRAGOMAN_ENV}
+	secretId, err := cs.variables.Expand(string(payload.SecretID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cs.policy.CheckSecretId(secretId); err != nil {
+		return nil, err
+	}
+
+	var secretKey string
+	if payload.SecretKey != nil {
+		if secretKey, err = cs.variables.Expand(string(payload.SecretKey)); err != nil {
+			return nil, err
+		}
+	}
+
+	version := SecretVersion{Id: string(payload.VersionId), Stage: string(payload.VersionStage)}
+
+	return cs.GetSecretVersion(secretId, secretKey, version)
+}
+
+// GetSecret pulls the current version of a secret from Secrets Manager.
+// When key is provided the secret is expected to be a JSON object and the value under key is returned.
+func (cs SecretsManagerCryptoStrategy) GetSecret(secretId string, key string) ([]byte, error) {
+	return cs.GetSecretVersion(secretId, key, SecretVersion{})
+}
 
-	// Pull the key from KMS
+// GetSecretVersion pulls a version of a string or binary secret from Secrets Manager.
+// When key is provided the secret is expected to be JSON and the value at the key, or at a path such as
+// "db.primary.password" or "hosts[0]", is returned. A key or path that does not resolve is an error.
+func (cs SecretsManagerCryptoStrategy) GetSecretVersion(secretId string, key string, version SecretVersion) ([]byte, error) {
 	var resp *sm.GetSecretValueOutput
-	if resp, err = cs.client.GetSecretValue(
-		context.TODO(),
-		&sm.GetSecretValueInput{
-			SecretId: &secretId,
-		}); err != nil {
+	var err error
+
+	if resp, err = cs.client.GetSecretValue(context.TODO(), version.input(secretId)); err != nil {
 		return nil, fmt.Errorf("unable to decipher the secret: %v", err)
 	}
 
-	secretString := *resp.SecretString
-	if secretString == "" {
-		return nil, fmt.Errorf("only string secrets are currently supported")
+	secret := secretValue(resp)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the secret \"%s\" is empty", secretId)
 	}
 
-	if payload.SecretKey != nil {
-		secrets := map[string]string{}
-		json.Unmarshal([]byte(secretString), &secrets)
+	if key != "" {
+		value, err := lookupSecretKey(secret, key)
+		if err != nil {
+			return nil, fmt.Errorf("the secret \"%s\" %v", secretId, err)
+		}
+
+		return value, nil
+	}
+
+	return secret, nil
+}
+
+// secretValue returns the string or binary value of a secret
+func secretValue(resp *sm.GetSecretValueOutput) []byte {
+	if resp.SecretString != nil {
+		return []byte(*resp.SecretString)
+	}
+
+	return resp.SecretBinary
+}
+
+// ValidateReference makes sure a secret can be used in an envelope: the version has to exist and, when key is
+// provided, be JSON that holds the key or path. The error lists the available keys when key is wrong.
+func (cs SecretsManagerCryptoStrategy) ValidateReference(secretId string, key string, version SecretVersion) error {
+	resp, err := cs.client.GetSecretValue(context.TODO(), version.input(secretId))
+	if err != nil {
+		return fmt.Errorf("unable to read the secret \"%s\": %v", secretId, err)
+	}
+
+	if key == "" {
+		return nil
+	}
 
-		return []byte(secrets[string(payload.SecretKey)]), nil
+	if _, err = lookupSecretKey(secretValue(resp), key); err != nil {
+		return fmt.Errorf("the secret \"%s\" %v", secretId, err)
 	}
 
-	return []byte(secretString), nil
+	return nil
+}
+
+// PutSecret writes value to a secret, creating the secret when it does not exist yet. When key is provided the secret
+// holds a JSON object and value is stored under key, keeping the other keys of the current version. kmsKeyId is only
+// used when the secret is created, an empty id uses the default aws/secretsmanager key.
+// It returns whether the secret was created.
+func (cs SecretsManagerCryptoStrategy) PutSecret(secretId string, key string, value []byte, kmsKeyId string) (bool, error) {
+	current, exists, err := cs.currentSecret(secretId)
+	if err != nil {
+		return false, err
+	}
+
+	if key != "" {
+		if value, err = mergeSecretKey(current, key, value); err != nil {
+			return false, fmt.Errorf("the secret \"%s\" %v", secretId, err)
+		}
+	}
+
+	if err = cs.writeSecret(secretId, exists, value, kmsKeyId); err != nil {
+		return false, err
+	}
+
+	return !exists, nil
+}
+
+// SecretWrite tells what PutNewSecret did
+type SecretWrite int
+
+const (
+	SecretCreated SecretWrite = iota
+	SecretUpdated
+	SecretUnchanged
+)
+
+// ErrSecretExists is returned by PutNewSecret when the secret already holds a different value
+var ErrSecretExists = errors.New("the secret already exists and holds a different value")
+
+// PutNewSecret writes the whole value of a secret like PutSecret, but refuses to replace a different value of an
+// existing secret unless overwrite is set, since Secrets Manager only keeps the previous version around.
+// A secret that already holds the value is left as it is.
+func (cs SecretsManagerCryptoStrategy) PutNewSecret(secretId string, value []byte, kmsKeyId string, overwrite bool) (SecretWrite, error) {
+	current, exists, err := cs.currentSecret(secretId)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case exists && bytes.Equal(current, value):
+		return SecretUnchanged, nil
+	case exists && !overwrite:
+		return 0, fmt.Errorf("\"%s\": %w", secretId, ErrSecretExists)
+	}
+
+	if err = cs.writeSecret(secretId, exists, value, kmsKeyId); err != nil {
+		return 0, err
+	}
+
+	if exists {
+		return SecretUpdated, nil
+	}
+
+	return SecretCreated, nil
+}
+
+// currentSecret reads the current value of a secret and whether it exists
+func (cs SecretsManagerCryptoStrategy) currentSecret(secretId string) ([]byte, bool, error) {
+	resp, err := cs.client.GetSecretValue(context.TODO(), SecretVersion{}.input(secretId))
+
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, fmt.Errorf("unable to read the secret \"%s\": %v", secretId, err)
+	}
+
+	return secretValue(resp), true, nil
+}
+
+// writeSecret stores value as a new version of the secret, or creates the secret when it does not exist
+func (cs SecretsManagerCryptoStrategy) writeSecret(secretId string, exists bool, value []byte, kmsKeyId string) error {
+	// Text is stored as a string secret, anything else as a binary secret
+	var secretString *string
+	var secretBinary []byte
+	if utf8.Valid(value) {
+		text := string(value)
+		secretString = &text
+	} else {
+		secretBinary = value
+	}
+
+	if !exists {
+		input := &sm.CreateSecretInput{Name: &secretId, SecretString: secretString, SecretBinary: secretBinary}
+		if kmsKeyId != "" {
+			input.KmsKeyId = &kmsKeyId
+		}
+
+		if _, err := cs.client.CreateSecret(context.TODO(), input); err != nil {
+			return fmt.Errorf("unable to create the secret \"%s\": %v", secretId, err)
+		}
+
+		return nil
+	}
+
+	if _, err := cs.client.PutSecretValue(context.TODO(), &sm.PutSecretValueInput{
+		SecretId:     &secretId,
+		SecretString: secretString,
+		SecretBinary: secretBinary,
+	}); err != nil {
+		return fmt.Errorf("unable to update the secret \"%s\": %v", secretId, err)
+	}
+
+	return nil
+}
+
+// mergeSecretKey sets key to value in the JSON object of a secret, an empty secret starts a new object.
+// The errors complete a sentence starting with the secret, like the ones of lookupSecretKey.
+func mergeSecretKey(secret []byte, key string, value []byte) ([]byte, error) {
+	if !utf8.Valid(value) {
+		return nil, fmt.Errorf("can only hold text under the key \"%s\"", key)
+	}
+
+	object := map[string]interface{}{}
+
+	if len(secret) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(secret))
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&object); err != nil {
+			return nil, fmt.Errorf("is not a JSON object, so the key \"%s\" cannot be added to it", key)
+		}
+	}
+
+	if object == nil {
+		object = map[string]interface{}{}
+	}
+
+	object[key] = string(value)
+
+	return stringifySecretValue(object)
+}
+
+// inspectSmEnvelope reads the secret reference held by a Secrets Manager envelope payload
+func inspectSmEnvelope(encrypted []byte) (*EnvelopeInfo, error) {
+	var payload smEnvelopeEncryptionPayload
+	if err := gob.NewDecoder(bytes.NewReader(encrypted)).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("failed to decode the message payload: %v", err)
+	}
+
+	if len(payload.SecretID) == 0 {
+		return nil, fmt.Errorf("the envelope is missing its secret id")
+	}
+
+	metadata, err := decodeMetadata(payload.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvelopeInfo{
+		Strategy:      CRYPTO_KEY_SM,
+		Version:       payloadVersion(payload.Version),
+		SecretId:      string(payload.SecretID),
+		SecretKey:     string(payload.SecretKey),
+		SecretVersion: string(payload.VersionId),
+		SecretStage:   string(payload.VersionStage),
+		Size:          len(encrypted),
+		Metadata:      metadata,
+	}, nil
 }
@@ -2,9 +2,13 @@ package cryptography
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -19,6 +23,18 @@ func (m *smClientMock) GetSecretValue(ctx context.Context, input *sm.GetSecretVa
 	return args.Get(0).(*sm.GetSecretValueOutput), args.Error(1)
 }
 
+func (m *smClientMock) PutSecretValue(ctx context.Context, input *sm.PutSecretValueInput, opts ...func(*sm.Options)) (*sm.PutSecretValueOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*sm.PutSecretValueOutput), args.Error(1)
+}
+
+func (m *smClientMock) CreateSecret(ctx context.Context, input *sm.CreateSecretInput, opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*sm.CreateSecretOutput), args.Error(1)
+}
+
 func getMockSecretsManagerStrategy() (strategy *SecretsManagerCryptoStrategy, smClient *smClientMock) {
 	smClient = new(smClientMock)
 	strategy = &SecretsManagerCryptoStrategy{
@@ -113,3 +129,312 @@ func TestSmDecrypt(t *testing.T) {
 		assert.Equal(t, "Jon Snow gets resurrected", string(decrypted))
 	})
 }
+
+func TestSmDecryptWithReferenceVariables(t *testing.T) {
+	t.Run("it should expand the placeholders of the secret id and key", func(t *testing.T) {
+		secret := `{"prod":{"password":"hunter2"}}`
+		var encrypted string
+		generateMockSmEncryptedString("${DRAGOMAN_ENV}/db", "${DRAGOMAN_ENV}.password", &encrypted)
+
+		strategy, mockSm := getMockSecretsManagerStrategy()
+		strategy = strategy.WithReferenceVariables(NewReferenceVariables(nil, map[string]string{"DRAGOMAN_ENV": "prod"}))
+
+		mockSm.On("GetSecretValue", context.TODO(), &sm.GetSecretValueInput{
+			SecretId: aws.String("prod/db"),
+		}, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretString: &secret,
+			}, nil)
+
+		decrypted, err := strategy.Decrypt(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hunter2", string(decrypted))
+	})
+
+	t.Run("it should not call secrets manager when a placeholder cannot be expanded", func(t *testing.T) {
+		var encrypted string
+		generateMockSmEncryptedString("${DRAGOMAN_ENV}/db", "", &encrypted)
+
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		_, err := strategy.Decrypt(encrypted)
+
+		assert.EqualError(t, err, `the variable "DRAGOMAN_ENV" in "${DRAGOMAN_ENV}/db" is not allowed to be expanded, allowed variables: none`)
+		mockSm.AssertNotCalled(t, "GetSecretValue", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestSmGetSecret(t *testing.T) {
+	t.Run("it should return the value for the key of a JSON secret", func(t *testing.T) {
+		superSecret := "{\"myKey\":\"Jon Snow gets resurrected\"}"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		secretId := "aKey"
+		mockSm.On("GetSecretValue", context.TODO(), &sm.GetSecretValueInput{SecretId: &secretId}, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretString: &superSecret,
+			}, nil)
+
+		value, err := strategy.GetSecret(secretId, "myKey")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "Jon Snow gets resurrected", string(value))
+	})
+}
+
+func TestSmGetSecretVersion(t *testing.T) {
+	t.Run("it should return binary secrets", func(t *testing.T) {
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretBinary: []byte{0, 1, 2},
+			}, nil)
+
+		value, err := strategy.GetSecretVersion("aKey", "", SecretVersion{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{0, 1, 2}, value)
+	})
+
+	t.Run("it should read the version pinned in the envelope", func(t *testing.T) {
+		previous := "the old password"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		encrypted, err := strategy.EncryptVersion([]byte("aKey"), "", SecretVersion{Stage: "AWSPREVIOUS"})
+		assert.Nil(t, err)
+
+		mockSm.On("GetSecretValue", context.TODO(), &sm.GetSecretValueInput{
+			SecretId:     aws.String("aKey"),
+			VersionStage: aws.String("AWSPREVIOUS"),
+		}, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretString: &previous,
+			}, nil)
+
+		decrypted, err := strategy.Decrypt(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, previous, string(decrypted))
+	})
+
+	t.Run("it should read the current version of envelopes without a pinned version", func(t *testing.T) {
+		current := "the password"
+		var encrypted string
+		generateMockSmEncryptedString("aKey", "", &encrypted)
+
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), &sm.GetSecretValueInput{SecretId: aws.String("aKey")}, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretString: &current,
+			}, nil)
+
+		decrypted, err := strategy.Decrypt(encrypted)
+
+		assert.Nil(t, err)
+		assert.Equal(t, current, string(decrypted))
+	})
+}
+
+func TestSmValidateReference(t *testing.T) {
+	t.Run("it should accept an existing key of a JSON secret", func(t *testing.T) {
+		superSecret := "{\"myKey\":\"Jon Snow gets resurrected\"}"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretString: &superSecret,
+			}, nil)
+
+		assert.Nil(t, strategy.ValidateReference("aKey", "myKey", SecretVersion{}))
+		assert.Nil(t, strategy.ValidateReference("aKey", "", SecretVersion{}))
+	})
+
+	t.Run("it should list the available keys when the key is wrong", func(t *testing.T) {
+		superSecret := "{\"password\":\"a\",\"username\":\"b\"}"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretString: &superSecret,
+			}, nil)
+
+		err := strategy.ValidateReference("aKey", "pasword", SecretVersion{})
+
+		assert.EqualError(t, err, "the secret \"aKey\" has no key \"pasword\", available keys: password, username")
+	})
+
+	t.Run("it should accept binary secrets and reject keys of secrets that are not JSON objects", func(t *testing.T) {
+		plain := "not json"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), &sm.GetSecretValueInput{SecretId: aws.String("binary")}, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretBinary: []byte{1, 2, 3},
+			}, nil)
+		mockSm.On("GetSecretValue", context.TODO(), &sm.GetSecretValueInput{SecretId: aws.String("plain")}, mock.Anything).Return(
+			&sm.GetSecretValueOutput{
+				SecretString: &plain,
+			}, nil)
+
+		assert.Nil(t, strategy.ValidateReference("binary", "", SecretVersion{}))
+		assert.Error(t, strategy.ValidateReference("binary", "myKey", SecretVersion{}))
+		assert.Error(t, strategy.ValidateReference("plain", "myKey", SecretVersion{}))
+	})
+
+	t.Run("it should report secrets that cannot be read", func(t *testing.T) {
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{}, fmt.Errorf("ResourceNotFoundException"))
+
+		assert.Error(t, strategy.ValidateReference("missing", "", SecretVersion{}))
+	})
+}
+
+func TestSmPutSecret(t *testing.T) {
+	t.Run("it should merge the key into the JSON of an existing secret", func(t *testing.T) {
+		existing := `{"user":"admin","port":5432,"password":"old"}`
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), &sm.GetSecretValueInput{SecretId: aws.String("app/db")}, mock.Anything).Return(
+			&sm.GetSecretValueOutput{SecretString: &existing}, nil)
+		mockSm.On("PutSecretValue", context.TODO(), &sm.PutSecretValueInput{
+			SecretId:     aws.String("app/db"),
+			SecretString: aws.String(`{"password":"<new>","port":5432,"user":"admin"}`),
+		}, mock.Anything).Return(&sm.PutSecretValueOutput{}, nil)
+
+		created, err := strategy.PutSecret("app/db", "password", []byte("<new>"), "")
+
+		assert.Nil(t, err)
+		assert.False(t, created)
+		mockSm.AssertExpectations(t)
+	})
+
+	t.Run("it should create a missing secret with the key", func(t *testing.T) {
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{}, &types.ResourceNotFoundException{Message: aws.String("not found")})
+		mockSm.On("CreateSecret", context.TODO(), &sm.CreateSecretInput{
+			Name:         aws.String("app/db"),
+			SecretString: aws.String(`{"password":"hunter2"}`),
+			KmsKeyId:     aws.String("alias/app"),
+		}, mock.Anything).Return(&sm.CreateSecretOutput{}, nil)
+
+		created, err := strategy.PutSecret("app/db", "password", []byte("hunter2"), "alias/app")
+
+		assert.Nil(t, err)
+		assert.True(t, created)
+		mockSm.AssertExpectations(t)
+	})
+
+	t.Run("it should replace the whole value without a key, storing binary values as binary", func(t *testing.T) {
+		value := []byte{0xff, 0x00, 0x01}
+		existing := "old"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{SecretString: &existing}, nil)
+		mockSm.On("PutSecretValue", context.TODO(), &sm.PutSecretValueInput{
+			SecretId:     aws.String("app/tls-key"),
+			SecretBinary: value,
+		}, mock.Anything).Return(&sm.PutSecretValueOutput{}, nil)
+
+		_, err := strategy.PutSecret("app/tls-key", "", value, "")
+
+		assert.Nil(t, err)
+		mockSm.AssertExpectations(t)
+	})
+
+	t.Run("it should refuse to add a key to a secret that is not a JSON object", func(t *testing.T) {
+		existing := "plain text"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{SecretString: &existing}, nil)
+
+		_, err := strategy.PutSecret("app/db", "password", []byte("hunter2"), "")
+
+		assert.EqualError(t, err, `the secret "app/db" is not a JSON object, so the key "password" cannot be added to it`)
+		mockSm.AssertNotCalled(t, "PutSecretValue", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should not create the secret when it cannot be read", func(t *testing.T) {
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{}, fmt.Errorf("AccessDeniedException"))
+
+		_, err := strategy.PutSecret("app/db", "password", []byte("hunter2"), "")
+
+		assert.EqualError(t, err, `unable to read the secret "app/db": AccessDeniedException`)
+		mockSm.AssertNotCalled(t, "CreateSecret", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestSmPutNewSecret(t *testing.T) {
+	t.Run("it should refuse to replace a secret holding a different value", func(t *testing.T) {
+		existing := "live production value"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{SecretString: &existing}, nil)
+
+		_, err := strategy.PutNewSecret("app/db.password", []byte("hunter2"), "", false)
+
+		assert.True(t, errors.Is(err, ErrSecretExists))
+		assert.EqualError(t, err, `"app/db.password": the secret already exists and holds a different value`)
+		mockSm.AssertNotCalled(t, "PutSecretValue", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should leave a secret that already holds the value alone", func(t *testing.T) {
+		existing := "hunter2"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{SecretString: &existing}, nil)
+
+		written, err := strategy.PutNewSecret("app/db.password", []byte("hunter2"), "", false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, SecretUnchanged, written)
+		mockSm.AssertNotCalled(t, "PutSecretValue", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should replace a different value when asked to", func(t *testing.T) {
+		existing := "old"
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{SecretString: &existing}, nil)
+		mockSm.On("PutSecretValue", context.TODO(), &sm.PutSecretValueInput{
+			SecretId:     aws.String("app/db.password"),
+			SecretString: aws.String("hunter2"),
+		}, mock.Anything).Return(&sm.PutSecretValueOutput{}, nil)
+
+		written, err := strategy.PutNewSecret("app/db.password", []byte("hunter2"), "", true)
+
+		assert.Nil(t, err)
+		assert.Equal(t, SecretUpdated, written)
+		mockSm.AssertExpectations(t)
+	})
+
+	t.Run("it should create a missing secret", func(t *testing.T) {
+		strategy, mockSm := getMockSecretsManagerStrategy()
+
+		mockSm.On("GetSecretValue", context.TODO(), mock.Anything, mock.Anything).Return(
+			&sm.GetSecretValueOutput{}, &types.ResourceNotFoundException{Message: aws.String("not found")})
+		mockSm.On("CreateSecret", context.TODO(), &sm.CreateSecretInput{
+			Name:         aws.String("app/db.password"),
+			SecretString: aws.String("hunter2"),
+		}, mock.Anything).Return(&sm.CreateSecretOutput{}, nil)
+
+		written, err := strategy.PutNewSecret("app/db.password", []byte("hunter2"), "", false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, SecretCreated, written)
+	})
+}
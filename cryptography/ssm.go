@@ -0,0 +1,47 @@
+package cryptography
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// ssmClientIfc allows us to mock the ssm client in tests
+type ssmClientIfc interface {
+	GetParameter(context.Context, *ssm.GetParameterInput, ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// SsmParameterStore reads parameters from AWS Systems Manager Parameter Store
+type SsmParameterStore struct {
+	client ssmClientIfc
+}
+
+func NewSsmParameterStore(region string) (*SsmParameterStore, error) {
+	cfg, err := loadAwsConfig(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SsmParameterStore{
+		client: ssm.NewFromConfig(cfg),
+	}, nil
+}
+
+// GetParameter returns the value of a parameter, decrypting SecureString parameters
+func (ps SsmParameterStore) GetParameter(name string) (string, error) {
+	resp, err := ps.client.GetParameter(context.TODO(), &ssm.GetParameterInput{
+		Name:           &name,
+		WithDecryption: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to read parameter \"%s\": %v", name, err)
+	}
+
+	if resp.Parameter == nil {
+		return "", fmt.Errorf("parameter \"%s\" has no value", name)
+	}
+
+	return aws.ToString(resp.Parameter.Value), nil
+}
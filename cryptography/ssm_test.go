@@ -0,0 +1,69 @@
+package cryptography
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type ssmClientMock struct {
+	mock.Mock
+}
+
+func (m *ssmClientMock) GetParameter(ctx context.Context, input *ssm.GetParameterInput, opts ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	args := m.Called(ctx, input, opts)
+
+	return args.Get(0).(*ssm.GetParameterOutput), args.Error(1)
+}
+
+func getMockSsmParameterStore() (store *SsmParameterStore, ssmClient *ssmClientMock) {
+	ssmClient = new(ssmClientMock)
+	store = &SsmParameterStore{
+		client: ssmClient,
+	}
+
+	return
+}
+
+func TestSsmParameterStoreBuilder(t *testing.T) {
+	t.Run("it should generate the ssm client", func(t *testing.T) {
+		store, err := NewSsmParameterStore("us-east-1")
+
+		assert.NotNil(t, store)
+		assert.NotNil(t, store.client)
+		assert.Nil(t, err)
+	})
+}
+
+func TestSsmGetParameter(t *testing.T) {
+	t.Run("it should return the decrypted parameter value", func(t *testing.T) {
+		store, mockSsm := getMockSsmParameterStore()
+
+		name := "/app/db/password"
+		mockSsm.On("GetParameter", context.TODO(), &ssm.GetParameterInput{Name: &name, WithDecryption: true}, mock.Anything).Return(
+			&ssm.GetParameterOutput{
+				Parameter: &types.Parameter{Value: aws.String("Winter is coming")},
+			}, nil)
+
+		value, err := store.GetParameter(name)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "Winter is coming", value)
+	})
+
+	t.Run("it should return an error if the parameter cannot be read", func(t *testing.T) {
+		store, mockSsm := getMockSsmParameterStore()
+
+		mockSsm.On("GetParameter", context.TODO(), mock.Anything, mock.Anything).Return(&ssm.GetParameterOutput{}, fmt.Errorf("not found"))
+
+		_, err := store.GetParameter("/missing")
+
+		assert.Error(t, err)
+	})
+}
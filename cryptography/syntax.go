@@ -0,0 +1,120 @@
+package cryptography
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Syntax describes how an envelope is written. The "TYPE,payload" body of an envelope sits between the prefix and suffix,
+// so the default syntax is ENC[TYPE,payload].
+type Syntax struct {
+	Prefix string
+	Suffix string
+}
+
+// DefaultSyntax is the ENC[...] syntax dragoman has always used
+var DefaultSyntax = Syntax{Prefix: "ENC[", Suffix: "]"}
+
+// syntaxPlaceholder marks where the envelope body goes in a syntax pattern such as "DRAGOMAN[...]"
+const syntaxPlaceholder = "..."
+
+// syntaxes are the recognised syntaxes, the first one is used when encrypting
+var syntaxes = []Syntax{DefaultSyntax}
+
+// ParseSyntax reads a syntax pattern, for example "DRAGOMAN[...]" or "${dragoman:...}"
+func ParseSyntax(pattern string) (Syntax, error) {
+	if strings.Count(pattern, syntaxPlaceholder) != 1 {
+		return Syntax{}, fmt.Errorf("envelope syntax \"%s\" must contain \"%s\" exactly once", pattern, syntaxPlaceholder)
+	}
+
+	parts := strings.SplitN(pattern, syntaxPlaceholder, 2)
+	syntax := Syntax{Prefix: parts[0], Suffix: parts[1]}
+
+	return syntax, syntax.validate()
+}
+
+// String returns the syntax as a pattern that ParseSyntax understands
+func (s Syntax) String() string {
+	return s.Prefix + syntaxPlaceholder + s.Suffix
+}
+
+// Wrap writes an envelope of the given type and base64 payload in this syntax
+func (s Syntax) Wrap(key string, payload string) string {
+	return s.Prefix + key + "," + payload + s.Suffix
+}
+
+// validate makes sure the envelope body can be told apart from the prefix and suffix
+func (s Syntax) validate() error {
+	if strings.TrimSpace(s.Prefix) == "" || strings.TrimSpace(s.Suffix) == "" {
+		return fmt.Errorf("envelope syntax \"%s\" needs a prefix and a suffix", s)
+	}
+
+	if strings.ContainsAny(s.Prefix+s.Suffix, " \t\r\n") {
+		return fmt.Errorf("envelope syntax \"%s\" cannot contain whitespace", s)
+	}
+
+	// A suffix starting with a base64 character would be swallowed by the payload
+	if payloadCharRegex.MatchString(s.Suffix[:1]) {
+		return fmt.Errorf("envelope syntax \"%s\" cannot have a suffix starting with \"%c\"", s, s.Suffix[0])
+	}
+
+	return nil
+}
+
+// Syntaxes returns the recognised envelope syntaxes, the first one is used when encrypting
+func Syntaxes() []Syntax {
+	return append([]Syntax{}, syntaxes...)
+}
+
+// SetSyntaxes changes the envelope syntaxes that are recognised when decrypting.
+// New envelopes are written with the first syntax, the others are only read, which allows moving files from one
+// syntax to another. This is meant to be called once at startup, it is not safe to call while envelopes are processed.
+func SetSyntaxes(list []Syntax) error {
+	if len(list) == 0 {
+		return fmt.Errorf("at least one envelope syntax is required")
+	}
+
+	for _, syntax := range list {
+		if err := syntax.validate(); err != nil {
+			return err
+		}
+	}
+
+	syntaxes = append([]Syntax{}, list...)
+	EnvelopeRegex, envelopePrefixRegex = compileSyntaxes(syntaxes)
+
+	return nil
+}
+
+var payloadCharRegex = regexp.MustCompile(`[a-zA-Z0-9+/=]`)
+
+// compileSyntaxes builds the envelope and envelope prefix expressions for a list of syntaxes.
+// Every syntax adds a group for the type and, in the envelope expression, a group for the payload.
+func compileSyntaxes(list []Syntax) (*regexp.Regexp, *regexp.Regexp) {
+	types := strings.Join(validStrategies, "|")
+	full := make([]string, 0, len(list))
+	prefixes := make([]string, 0, len(list))
+
+	for _, syntax := range list {
+		prefix := regexp.QuoteMeta(syntax.Prefix)
+
+		full = append(full, fmt.Sprintf("%s(%s),([a-zA-Z0-9+/=\\s]+)%s", prefix, types, regexp.QuoteMeta(syntax.Suffix)))
+		prefixes = append(prefixes, fmt.Sprintf("%s(%s),", prefix, types))
+	}
+
+	return regexp.MustCompile(strings.Join(full, "|")), regexp.MustCompile(strings.Join(prefixes, "|"))
+}
+
+// matchedGroups returns the index pairs of the groups that took part in a match, since only the groups of the
+// syntax that matched are set
+func matchedGroups(match []int) []int {
+	groups := []int{}
+	for i := 2; i+1 < len(match); i += 2 {
+		if match[i] >= 0 {
+			groups = append(groups, match[i], match[i+1])
+		}
+	}
+
+	return groups
+}
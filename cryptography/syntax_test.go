@@ -0,0 +1,85 @@
+package cryptography
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withSyntaxes runs fn with the given syntaxes configured and restores the default afterwards
+func withSyntaxes(t *testing.T, patterns []string, fn func()) {
+	list := []Syntax{}
+	for _, pattern := range patterns {
+		syntax, err := ParseSyntax(pattern)
+		assert.Nil(t, err)
+		list = append(list, syntax)
+	}
+
+	assert.Nil(t, SetSyntaxes(list))
+	defer SetSyntaxes([]Syntax{DefaultSyntax})
+
+	fn()
+}
+
+func TestParseSyntax(t *testing.T) {
+	t.Run("it should split the pattern around the placeholder", func(t *testing.T) {
+		syntax, err := ParseSyntax("${dragoman:...}")
+
+		assert.Nil(t, err)
+		assert.Equal(t, Syntax{Prefix: "${dragoman:", Suffix: "}"}, syntax)
+		assert.Equal(t, "${dragoman:...}", syntax.String())
+	})
+
+	t.Run("it should reject patterns it cannot tell apart from the payload", func(t *testing.T) {
+		for _, pattern := range []string{"DRAGOMAN", "...]", "ENC[...", "ENC[...A]", "ENC [...]", "A[...]..."} {
+			_, err := ParseSyntax(pattern)
+			assert.NotNil(t, err, pattern)
+		}
+	})
+}
+
+func TestSetSyntaxes(t *testing.T) {
+	t.Run("it should recognise every configured syntax", func(t *testing.T) {
+		withSyntaxes(t, []string{"DRAGOMAN[...]", "${dragoman:...}", "ENC[...]"}, func() {
+			envelopes := FindEnvelopes("a: DRAGOMAN[KMS,YWJj]\nb: ${dragoman:SECMAN,ZGVm}\nc: ENC[KMS,Z2hp]\n")
+
+			assert.Len(t, envelopes, 3)
+			assert.Equal(t, "KMS", envelopes[0].Type)
+			assert.Equal(t, "SECMAN", envelopes[1].Type)
+			assert.Equal(t, "${dragoman:SECMAN,ZGVm}", envelopes[1].Raw)
+			assert.Equal(t, "ENC[KMS,Z2hp]", envelopes[2].Raw)
+		})
+	})
+
+	t.Run("it should only recognise the configured syntaxes", func(t *testing.T) {
+		withSyntaxes(t, []string{"DRAGOMAN[...]"}, func() {
+			assert.Empty(t, FindEnvelopes("ENC[KMS,YWJj]"))
+			assert.Equal(t, "", ExtractEncryptionType("ENC[KMS,YWJj]"))
+			assert.Equal(t, "KMS", ExtractEncryptionType("DRAGOMAN[KMS,YWJj]"))
+		})
+	})
+
+	t.Run("it should write envelopes with the first syntax", func(t *testing.T) {
+		withSyntaxes(t, []string{"${dragoman:...}", "ENC[...]"}, func() {
+			envelope := WrapEncoding("KMS", []byte("abc"))
+			assert.Equal(t, "${dragoman:KMS,YWJj}", envelope)
+
+			message, err := UnwrapEncoding(envelope)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("abc"), message)
+		})
+	})
+
+	t.Run("it should spot broken envelopes in any syntax", func(t *testing.T) {
+		withSyntaxes(t, []string{"${dragoman:...}", "ENC[...]"}, func() {
+			broken := FindBrokenEnvelopes("a: ${dragoman:KMS,YWJj\nb: ENC[KMS,YWJj]")
+
+			assert.Len(t, broken, 1)
+			assert.Equal(t, 1, broken[0].Line)
+		})
+	})
+
+	t.Run("it should require a syntax", func(t *testing.T) {
+		assert.NotNil(t, SetSyntaxes(nil))
+	})
+}
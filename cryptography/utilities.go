@@ -1,11 +1,15 @@
 package cryptography
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
-	"regexp"
 	"strings"
+	"time"
 	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
 )
 
 var (
@@ -13,10 +17,38 @@ var (
 		"KMS",
 		"SECMAN",
 	}
-	regexstr      = fmt.Sprintf("ENC\\[(%s),[a-zA-Z0-9+/=\\s]+\\]", strings.Join(validStrategies, "|"))
-	EnvelopeRegex = regexp.MustCompile(regexstr)
+
+	// EnvelopeRegex matches envelopes in any of the configured syntaxes, see SetSyntaxes.
+	// envelopePrefixRegex matches the start of an envelope, used to spot envelopes that are not well formed.
+	EnvelopeRegex, envelopePrefixRegex = compileSyntaxes(syntaxes)
 )
 
+// loadAwsConfig loads the shared AWS configuration, overriding the region when one is provided
+func loadAwsConfig(region string) (aws.Config, error) {
+	if region == "" {
+		return config.LoadDefaultConfig(context.TODO())
+	}
+
+	return config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+}
+
+// AwsCredentialsAvailable reports whether AWS credentials can be found. No service APIs are called, but retrieving
+// the credentials may contact credential providers such as the instance metadata service, STS or SSO.
+func AwsCredentialsAvailable(region string) bool {
+	cfg, err := loadAwsConfig(region)
+	if err != nil || cfg.Credentials == nil {
+		return false
+	}
+
+	// Keep the lookup short when the instance metadata service is not reachable
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err = cfg.Credentials.Retrieve(ctx)
+
+	return err == nil
+}
+
 // Converts a byte slice to a [32]byte as expected by NaCL
 func AsNaCLKey(data []byte) (*[32]byte, error) {
 	if len(data) != 32 {
@@ -29,13 +61,16 @@ func AsNaCLKey(data []byte) (*[32]byte, error) {
 }
 
 func WrapEncoding(key string, message []byte) string {
-	return fmt.Sprintf("ENC[%s,%s]",
-		key,
-		base64.StdEncoding.EncodeToString(message))
+	return syntaxes[0].Wrap(key, base64.StdEncoding.EncodeToString(message))
 }
 
 func UnwrapEncoding(input string) ([]byte, error) {
-	encoded := strings.Split(input[0:len(input)-1], ",")[1]
+	groups := matchedGroups(EnvelopeRegex.FindStringSubmatchIndex(input))
+	if len(groups) < 4 {
+		return nil, fmt.Errorf("input is not an envelope")
+	}
+
+	encoded := stripWhitespace(input[groups[2]:groups[3]])
 	message := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
 
 	n, err := base64.StdEncoding.Decode(message, []byte(encoded))
@@ -47,9 +82,8 @@ func UnwrapEncoding(input string) ([]byte, error) {
 }
 
 func ExtractEncryptionType(input string) string {
-	submatches := EnvelopeRegex.FindStringSubmatch(input)
-	if submatches != nil {
-		return submatches[1]
+	if groups := matchedGroups(EnvelopeRegex.FindStringSubmatchIndex(input)); len(groups) > 0 {
+		return input[groups[0]:groups[1]]
 	}
 
 	return ""
@@ -66,31 +100,7 @@ func stripWhitespace(a string) string {
 	}, a)
 }
 
-func DecryptEnvelopes(input string, strategy Decryptor) (output string, err error) {
-	// Recover from any panics that happen at a lower level
-	defer func() {
-		if r := recover(); r != nil {
-			var ok bool
-			err, ok = r.(error)
-			if !ok {
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
-
-	replfn := func(envelope string) string {
-		var data []byte
-		var err error
-
-		if data, err = strategy.Decrypt(stripWhitespace(envelope)); err != nil {
-			panic(err)
-		}
-
-		return string(data)
-	}
-
-	output = EnvelopeRegex.ReplaceAllStringFunc(input, replfn)
-
-	return
-
+// DecryptEnvelopes decrypts every envelope in the input, stopping at the first one that fails with an *EnvelopeError
+func DecryptEnvelopes(input string, strategy Decryptor) (string, error) {
+	return DecryptEnvelopesWithOptions(input, strategy, DecryptOptions{})
 }
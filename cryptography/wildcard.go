@@ -1,18 +1,34 @@
 package cryptography
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrSkipped is returned when decrypting an envelope whose type the wildcard strategy was told to skip.
+// DecryptEnvelopes and DecryptEnvelopesWithOptions leave such envelopes untouched.
+var ErrSkipped = errors.New("envelope type skipped")
 
 type WildcardDecryptionStrategy struct {
 	Strategies map[string]Decryptor
+
+	state *wildcardState // Shared by copies of the strategy, nil for strategies that were not set up by a constructor
+}
+
+// wildcardState is kept behind a pointer so Key and Decrypt can keep their value receivers
+type wildcardState struct {
+	builders map[string]StrategyBuilder // Strategies that are only built the first time they are needed
+	skipped  map[string]bool
+	policy   *Policy // Checked before any envelope is handed to a strategy
+	mutex    sync.Mutex
 }
 
 type StrategyBuilder func() (Decryptor, error)
 
 // NewWildcardDecryptionStrategy is the initializer function for WildcardDecryptionStrategy
 func NewWildcardDecryptionStrategy(builders []StrategyBuilder) (*WildcardDecryptionStrategy, error) {
-	wcStrat := &WildcardDecryptionStrategy{
-		Strategies: make(map[string]Decryptor),
-	}
+	wcStrat := NewLazyWildcardDecryptionStrategy(nil)
 
 	for _, builder := range builders {
 		strat, err := builder()
@@ -26,6 +42,21 @@ func NewWildcardDecryptionStrategy(builders []StrategyBuilder) (*WildcardDecrypt
 	return wcStrat, nil
 }
 
+// NewLazyWildcardDecryptionStrategy sets up a WildcardDecryptionStrategy that only builds the strategy for an
+// envelope type when the first envelope of that type is decrypted, so no clients are created for types that never appear
+func NewLazyWildcardDecryptionStrategy(builders map[string]StrategyBuilder) *WildcardDecryptionStrategy {
+	wcStrat := &WildcardDecryptionStrategy{
+		Strategies: make(map[string]Decryptor),
+	}
+	state := wcStrat.init()
+
+	for key, builder := range builders {
+		state.builders[key] = builder
+	}
+
+	return wcStrat
+}
+
 func (wds WildcardDecryptionStrategy) Key() string {
 	return "*"
 }
@@ -35,19 +66,105 @@ func (wds WildcardDecryptionStrategy) Decrypt(input string) ([]byte, error) {
 	// Figure out what the encryption strategy was
 	etype := ExtractEncryptionType(input)
 
-	var strategy Decryptor
-	var exists bool
+	strategy, err := wds.strategy(etype)
+	if err != nil {
+		return nil, err
+	}
 
-	if strategy, exists = wds.Strategies[etype]; !exists {
-		return nil, fmt.Errorf("not configured for decrypting ENC[%s,...] values", etype)
+	// Envelopes outside the policy are rejected before AWS is called
+	if wds.state != nil {
+		if err = wds.state.policy.CheckEnvelope(input); err != nil {
+			return nil, err
+		}
 	}
 
 	return strategy.Decrypt(input)
 }
 
+// strategy returns the strategy for an envelope type, building it on first use
+func (wds WildcardDecryptionStrategy) strategy(etype string) (Decryptor, error) {
+	if wds.state == nil {
+		if strategy, exists := wds.Strategies[etype]; exists {
+			return strategy, nil
+		}
+
+		return nil, fmt.Errorf("not configured for decrypting ENC[%s,...] values", etype)
+	}
+
+	wds.state.mutex.Lock()
+	defer wds.state.mutex.Unlock()
+
+	if wds.state.skipped[etype] {
+		return nil, ErrSkipped
+	}
+
+	if strategy, exists := wds.Strategies[etype]; exists {
+		return strategy, nil
+	}
+
+	builder, exists := wds.state.builders[etype]
+	if !exists {
+		return nil, fmt.Errorf("not configured for decrypting ENC[%s,...] values", etype)
+	}
+
+	strategy, err := builder()
+	if err != nil {
+		return nil, fmt.Errorf("unable to setup the %s decryption strategy: %v", etype, err)
+	}
+
+	wds.Strategies[etype] = strategy
+
+	return strategy, nil
+}
+
 // Add is a builder function to build up any applicable decryption strategies
 func (wds *WildcardDecryptionStrategy) Add(key string, strategy Decryptor) *WildcardDecryptionStrategy {
+	state := wds.init()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
 	wds.Strategies[key] = strategy
 
 	return wds
 }
+
+// Skip makes Decrypt return ErrSkipped for envelopes of the given types instead of decrypting them
+func (wds *WildcardDecryptionStrategy) Skip(keys ...string) *WildcardDecryptionStrategy {
+	state := wds.init()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	for _, key := range keys {
+		state.skipped[key] = true
+	}
+
+	return wds
+}
+
+// WithPolicy makes Decrypt reject envelopes that reference KMS keys or secrets outside the policy, before the
+// strategy for the envelope is called
+func (wds *WildcardDecryptionStrategy) WithPolicy(policy *Policy) *WildcardDecryptionStrategy {
+	state := wds.init()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.policy = policy
+
+	return wds
+}
+
+// init sets up the state of strategies that were created without a constructor
+func (wds *WildcardDecryptionStrategy) init() *wildcardState {
+	if wds.state == nil {
+		wds.state = &wildcardState{
+			builders: make(map[string]StrategyBuilder),
+			skipped:  make(map[string]bool),
+		}
+	}
+
+	if wds.Strategies == nil {
+		wds.Strategies = make(map[string]Decryptor)
+	}
+
+	return wds.state
+}
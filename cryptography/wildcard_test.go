@@ -0,0 +1,89 @@
+package cryptography
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazyWildcardDecryptionStrategy(t *testing.T) {
+	t.Run("it should only build a strategy when its type is decrypted", func(t *testing.T) {
+		built := map[string]int{}
+		strategy := NewLazyWildcardDecryptionStrategy(map[string]StrategyBuilder{
+			"KMS": func() (Decryptor, error) {
+				built["KMS"]++
+				return fakeDecryptor{}, nil
+			},
+			"SECMAN": func() (Decryptor, error) {
+				built["SECMAN"]++
+				return nil, fmt.Errorf("no credentials")
+			},
+		})
+
+		output, err := DecryptEnvelopes("a="+WrapEncoding("KMS", []byte("one"))+" b="+WrapEncoding("KMS", []byte("two")), strategy)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "a=one b=two", output)
+		assert.Equal(t, map[string]int{"KMS": 1}, built)
+	})
+
+	t.Run("it should report strategies that fail to build", func(t *testing.T) {
+		strategy := NewLazyWildcardDecryptionStrategy(map[string]StrategyBuilder{
+			"SECMAN": func() (Decryptor, error) { return nil, fmt.Errorf("no credentials") },
+		})
+
+		_, err := strategy.Decrypt(WrapEncoding("SECMAN", []byte("abc")))
+
+		assert.EqualError(t, err, "unable to setup the SECMAN decryption strategy: no credentials")
+	})
+
+	t.Run("it should leave skipped envelopes untouched", func(t *testing.T) {
+		strategy := NewLazyWildcardDecryptionStrategy(map[string]StrategyBuilder{
+			"KMS": func() (Decryptor, error) { return fakeDecryptor{}, nil },
+			"SECMAN": func() (Decryptor, error) {
+				t.Fatal("skipped strategies should not be built")
+				return nil, nil
+			},
+		}).Skip("SECMAN")
+
+		secman := WrapEncoding("SECMAN", []byte("abc"))
+		output, err := DecryptEnvelopes("a="+WrapEncoding("KMS", []byte("one"))+" b="+secman, strategy)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "a=one b="+secman, output)
+
+		_, err = strategy.Decrypt(secman)
+		assert.ErrorIs(t, err, ErrSkipped)
+	})
+}
+
+func TestWildcardDecryptionStrategyValue(t *testing.T) {
+	t.Run("it should satisfy Decryptor as a value, sharing the strategies built through copies", func(t *testing.T) {
+		built := 0
+		strategy := NewLazyWildcardDecryptionStrategy(map[string]StrategyBuilder{
+			CRYPTO_KEY_SM: func() (Decryptor, error) {
+				built++
+				return constantDecryptor("x"), nil
+			},
+		})
+
+		var decryptor Decryptor = *strategy
+		decryptor.Decrypt("ENC[SECMAN,abc]")
+		strategy.Decrypt("ENC[SECMAN,abc]")
+
+		assert.Equal(t, "*", decryptor.Key())
+		assert.Equal(t, 1, built)
+	})
+
+	t.Run("it should decrypt with strategies set up without a constructor", func(t *testing.T) {
+		strategy := WildcardDecryptionStrategy{Strategies: map[string]Decryptor{CRYPTO_KEY_SM: constantDecryptor("x")}}
+
+		decrypted, err := strategy.Decrypt("ENC[SECMAN,abc]")
+		assert.Nil(t, err)
+		assert.Equal(t, "x", string(decrypted))
+
+		_, err = strategy.Decrypt("ENC[KMS,abc]")
+		assert.EqualError(t, err, "not configured for decrypting ENC[KMS,...] values")
+	})
+}
@@ -0,0 +1,100 @@
+package formats
+
+import (
+	"fmt"
+	"strings"
+)
+
+func findDotenvValues(input string) ([]Value, error) {
+	values := []Value{}
+	starts := lineStarts(input)
+
+	for n := 0; n < len(starts); n++ {
+		pos := starts[n]
+		lineEnd := len(input)
+		if n+1 < len(starts) {
+			lineEnd = starts[n+1] - 1
+		}
+
+		line := input[pos:lineEnd]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Skip the indentation and an optional export keyword
+		pos += len(line) - len(strings.TrimLeft(line, " \t"))
+		if strings.HasPrefix(input[pos:lineEnd], "export ") {
+			pos += len("export ")
+		}
+
+		eq := strings.IndexByte(input[pos:lineEnd], '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n+1)
+		}
+
+		key := strings.TrimSpace(input[pos : pos+eq])
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key", n+1)
+		}
+
+		start := pos + eq + 1
+		for start < lineEnd && (input[start] == ' ' || input[start] == '\t') {
+			start++
+		}
+
+		value := Value{Path: []string{key}, Start: start}
+
+		switch {
+		case start < lineEnd && input[start] == '"':
+			end := start + 1
+			for ; end < len(input) && input[end] != '"'; end++ {
+				if input[end] == '\\' {
+					end++
+				}
+			}
+
+			if end >= len(input) {
+				return nil, fmt.Errorf("line %d: unterminated double quoted value", n+1)
+			}
+
+			value.Text = unescapeDotenv(input[start+1 : end])
+			value.Quote = '"'
+			value.End = end + 1
+		case start < lineEnd && input[start] == '\'':
+			end := strings.IndexByte(input[start+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quoted value", n+1)
+			}
+			end += start + 1
+
+			value.Text = input[start+1 : end]
+			value.Quote = '\''
+			value.End = end + 1
+		default:
+			// An unquoted value runs up to a comment or the end of the line
+			end := lineEnd
+			if comment := strings.Index(input[start:lineEnd], " #"); comment >= 0 {
+				end = start + comment
+			}
+
+			value.Text = strings.TrimRight(input[start:end], " \t\r")
+			value.End = start + len(value.Text)
+		}
+
+		// Quoted values may span several lines, carry on after the closing quote
+		for n+1 < len(starts) && starts[n+1] <= value.End {
+			n++
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func unescapeDotenv(value string) string {
+	replacer := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\r`, "\r", `\t`, "\t")
+
+	return replacer.Replace(value)
+}
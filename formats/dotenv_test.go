@@ -0,0 +1,53 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDotenvValues(t *testing.T) {
+	input := `# Secrets
+export DB_USER=admin
+DB_PASSWORD="s3cr\"t"
+API_KEY='literal' # comment
+MULTI="line one
+line two"
+EMPTY=
+`
+
+	t.Run("it should find every value with its key", func(t *testing.T) {
+		values, err := FindValues(Dotenv, input)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{
+			"DB_USER":     "admin",
+			"DB_PASSWORD": `s3cr"t`,
+			"API_KEY":     "literal",
+			"MULTI":       "line one\nline two",
+			"EMPTY":       "",
+		}, valueTexts(values))
+	})
+
+	t.Run("it should replace values in place and keep everything else", func(t *testing.T) {
+		output := replaceAll(t, Dotenv, input, map[string]string{
+			"DB_USER": "ENC[KMS,dXNlcg==]",
+			"API_KEY": "ENC[KMS,a2V5]",
+			"MULTI":   "ENC[KMS,bXVsdGk=]",
+		})
+
+		assert.Equal(t, `# Secrets
+export DB_USER=ENC[KMS,dXNlcg==]
+DB_PASSWORD="s3cr\"t"
+API_KEY='ENC[KMS,a2V5]' # comment
+MULTI="ENC[KMS,bXVsdGk=]"
+EMPTY=
+`, output)
+	})
+
+	t.Run("it should return an error for lines that are not assignments", func(t *testing.T) {
+		_, err := FindValues(Dotenv, "just some text\n")
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,295 @@
+package formats
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Escaper makes a value safe to write in place of input[start:end], based on the syntax surrounding that range
+type Escaper func(input string, start int, end int, value string) (string, error)
+
+// Shell is an escaping mode on top of the document formats, for shell scripts
+const Shell Format = "shell"
+
+// NewEscaper returns the escaper for a format (yaml, json, toml, dotenv or shell). "none" returns values unchanged.
+func NewEscaper(name string) (Escaper, error) {
+	switch strings.ToLower(name) {
+	case "none", "":
+		return func(_ string, _ int, _ int, value string) (string, error) { return value, nil }, nil
+	case string(Shell), "sh", "bash":
+		return escapeShell, nil
+	}
+
+	format, err := ParseFormat(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown escaping \"%s\", expected json, yaml, toml, dotenv, shell or none", name)
+	}
+
+	switch format {
+	case JSON:
+		return escapeJSON, nil
+	case YAML:
+		return escapeYAML, nil
+	case TOML:
+		return escapeTOML, nil
+	default:
+		return escapeDotenv, nil
+	}
+}
+
+// DetectEscaping picks the escaping for a file from its name, falling back to none
+func DetectEscaping(filename string) string {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), ".")) {
+	case "sh", "bash", "zsh", "ksh":
+		return string(Shell)
+	}
+
+	if format, err := DetectFormat(filename); err == nil {
+		return string(format)
+	}
+
+	return "none"
+}
+
+var (
+	jsonLiteralRegex = regexp.MustCompile(`^(-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?|true|false|null)$`)
+	tomlLiteralRegex = regexp.MustCompile(`^([+-]?[0-9][0-9_]*(\.[0-9_]+)?([eE][+-]?[0-9_]+)?|[+-]?(inf|nan)|true|false)$`)
+	shellBareRegex   = regexp.MustCompile(`^[A-Za-z0-9_./+=,:@%-]+$`)
+)
+
+func escapeJSON(input string, start int, end int, value string) (string, error) {
+	before, _ := lineContext(input, start, end)
+
+	if quote := scanQuotes(before, `"`, true); quote == '"' {
+		return unquote(QuoteJSON(value)), nil
+	}
+
+	// Keep numbers and other literals as they are, everything else becomes a string
+	if jsonLiteralRegex.MatchString(value) {
+		return value, nil
+	}
+
+	return QuoteJSON(value), nil
+}
+
+func escapeTOML(input string, start int, end int, value string) (string, error) {
+	before, _ := lineContext(input, start, end)
+
+	switch scanQuotes(before, `"'`, true) {
+	case '"':
+		return unquote(QuoteJSON(value)), nil
+	case '\'':
+		if strings.ContainsAny(value, "'\r\n") {
+			return "", fmt.Errorf("the value cannot be written inside a TOML literal string, use a basic string instead")
+		}
+
+		return value, nil
+	}
+
+	if tomlLiteralRegex.MatchString(value) {
+		return value, nil
+	}
+
+	return QuoteJSON(value), nil
+}
+
+func escapeDotenv(input string, start int, end int, value string) (string, error) {
+	before, _ := lineContext(input, start, end)
+
+	switch scanQuotes(before, `"'`, true) {
+	case '"':
+		return unquote(QuoteDotenv(value)), nil
+	case '\'':
+		if strings.ContainsAny(value, "'\r\n") {
+			return "", fmt.Errorf("the value cannot be written inside single quotes, use double quotes instead")
+		}
+
+		return value, nil
+	}
+
+	if dotenvBareSafe(value) {
+		return value, nil
+	}
+
+	// A bare value can only be quoted if the envelope is the whole value
+	if !strings.HasSuffix(strings.TrimRight(before, " \t"), "=") {
+		return "", fmt.Errorf("the value needs quoting but is part of a larger unquoted value")
+	}
+
+	return QuoteDotenv(value), nil
+}
+
+func escapeShell(input string, start int, end int, value string) (string, error) {
+	before, _ := lineContext(input, start, end)
+
+	switch scanShellQuotes(before) {
+	case '\'':
+		return strings.ReplaceAll(value, "'", `'\''`), nil
+	case '"':
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value), nil
+	}
+
+	if shellBareRegex.MatchString(value) {
+		return value, nil
+	}
+
+	return QuoteShell(value), nil
+}
+
+func escapeYAML(input string, start int, end int, value string) (string, error) {
+	before, after := lineContext(input, start, end)
+	indent := before[:len(before)-len(strings.TrimLeft(before, " "))]
+
+	switch scanYAMLQuotes(before) {
+	case '"':
+		return unquote(QuoteJSON(value)), nil
+	case '\'':
+		// Inside single quotes a line break is written as an empty line
+		value = strings.ReplaceAll(value, "'", "''")
+		return strings.ReplaceAll(value, "\n", "\n\n"+indent+"  "), nil
+	}
+
+	// The envelope starts its line, so it is the content of a block scalar (or a continued plain scalar)
+	if strings.TrimSpace(before) == "" {
+		return strings.ReplaceAll(value, "\n", "\n"+indent), nil
+	}
+
+	if !yamlNeedsQuotes(value) {
+		return value, nil
+	}
+
+	// Quoting is only possible when the envelope is the whole value of a key or sequence item
+	trimmedAfter := strings.TrimSpace(after)
+	whole := (strings.HasSuffix(before, ": ") || strings.HasSuffix(before, "- ")) &&
+		(trimmedAfter == "" || strings.HasPrefix(trimmedAfter, "#"))
+
+	if !whole {
+		return "", fmt.Errorf("the value needs quoting but is part of a larger unquoted value")
+	}
+
+	if strings.ContainsAny(value, "\n") && !strings.ContainsAny(value, "\r") && strings.TrimLeft(value, " \n") == value {
+		return yamlBlockScalar(value, indent+"  "), nil
+	}
+
+	return QuoteJSON(value), nil
+}
+
+// yamlBlockScalar writes a multi-line value as a literal block scalar, with the chomping indicator that keeps its trailing newlines
+func yamlBlockScalar(value string, indent string) string {
+	trimmed := strings.TrimRight(value, "\n")
+	indicator := "|"
+
+	switch trailing := len(value) - len(trimmed); {
+	case trailing == 0:
+		indicator = "|-"
+	case trailing > 1:
+		indicator = "|+"
+	}
+
+	lines := strings.Split(strings.TrimSuffix(value, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+
+	return indicator + "\n" + strings.Join(lines, "\n")
+}
+
+var yamlIndicatorRegex = regexp.MustCompile("^[-?:,\\[\\]{}#&*!|>'\"%@`]")
+
+// yamlNeedsQuotes reports whether the value would break the syntax of the document or lose content as a plain scalar.
+// Values that read back as numbers or booleans are left alone, the author chose to write them without quotes.
+func yamlNeedsQuotes(value string) bool {
+	return value == "" ||
+		strings.TrimSpace(value) != value ||
+		yamlIndicatorRegex.MatchString(value) ||
+		strings.Contains(value, ": ") || strings.HasSuffix(value, ":") ||
+		strings.Contains(value, " #") ||
+		strings.ContainsAny(value, "\n\r\t\x00")
+}
+
+// lineContext returns the text of the line before and after the range
+func lineContext(input string, start int, end int) (string, string) {
+	lineStart := strings.LastIndexByte(input[:start], '\n') + 1
+
+	lineEnd := strings.IndexByte(input[end:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(input)
+	} else {
+		lineEnd += end
+	}
+
+	return input[lineStart:start], input[end:lineEnd]
+}
+
+// scanQuotes returns the quote character that is open at the end of text, or zero.
+// Backslashes escape the next character inside double quotes when escapes is set.
+func scanQuotes(text string, quotes string, escapes bool) byte {
+	var open byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case open == 0 && strings.IndexByte(quotes, c) >= 0:
+			open = c
+		case open == '"' && escapes && c == '\\':
+			i++
+		case open != 0 && c == open:
+			open = 0
+		}
+	}
+
+	return open
+}
+
+// scanShellQuotes follows shell quoting rules, where a backslash also escapes outside of quotes
+func scanShellQuotes(text string) byte {
+	var open byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case open != '\'' && c == '\\':
+			i++
+		case open == 0 && (c == '\'' || c == '"'):
+			open = c
+		case open != 0 && c == open:
+			open = 0
+		}
+	}
+
+	return open
+}
+
+// scanYAMLQuotes only treats a quote as opening a scalar when it starts a value,
+// so apostrophes inside plain scalars are ignored
+func scanYAMLQuotes(text string) byte {
+	var open byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case open == 0 && (c == '"' || c == '\''):
+			prev := strings.TrimRight(text[:i], " ")
+			if prev == "" || strings.ContainsAny(prev[len(prev)-1:], ":-[{,") {
+				open = c
+			}
+		case open == '"' && c == '\\':
+			i++
+		case open == '\'' && c == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case open != 0 && c == open:
+			open = 0
+		}
+	}
+
+	return open
+}
+
+// unquote strips the surrounding quotes of an already escaped string
+func unquote(quoted string) string {
+	return quoted[1 : len(quoted)-1]
+}
@@ -0,0 +1,118 @@
+package formats
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// escapeAt runs the escaper for the ENC[...] placeholder found in the document
+func escapeAt(t *testing.T, name string, document string, value string) (string, error) {
+	escaper, err := NewEscaper(name)
+	assert.Nil(t, err)
+
+	start := strings.Index(document, "ENC[]")
+	output, err := escaper(document, start, start+len("ENC[]"), value)
+	if err != nil {
+		return "", err
+	}
+
+	return document[:start] + output + document[start+len("ENC[]"):], nil
+}
+
+func TestEscapeJSON(t *testing.T) {
+	t.Run("it should escape values inside strings", func(t *testing.T) {
+		output, err := escapeAt(t, "json", `{"password": "ENC[]"}`, "a\"b\\c\nd")
+
+		assert.Nil(t, err)
+		assert.Equal(t, `{"password": "a\"b\\c\nd"}`, output)
+	})
+
+	t.Run("it should quote bare values unless they are literals", func(t *testing.T) {
+		output, _ := escapeAt(t, "json", `{"port": ENC[]}`, "5432")
+		assert.Equal(t, `{"port": 5432}`, output)
+
+		output, _ = escapeAt(t, "json", `{"name": ENC[]}`, "admin")
+		assert.Equal(t, `{"name": "admin"}`, output)
+	})
+}
+
+func TestEscapeYAML(t *testing.T) {
+	t.Run("it should escape values inside quotes", func(t *testing.T) {
+		output, _ := escapeAt(t, "yaml", `password: "ENC[]"`, `a"b`)
+		assert.Equal(t, `password: "a\"b"`, output)
+
+		output, _ = escapeAt(t, "yaml", `password: 'ENC[]'`, `it's`)
+		assert.Equal(t, `password: 'it''s'`, output)
+	})
+
+	t.Run("it should leave plain values alone when they are safe", func(t *testing.T) {
+		output, _ := escapeAt(t, "yaml", "port: ENC[]", "5432")
+
+		assert.Equal(t, "port: 5432", output)
+	})
+
+	t.Run("it should quote plain values that would break the document", func(t *testing.T) {
+		output, _ := escapeAt(t, "yaml", "password: ENC[] # comment", "a: #b")
+
+		assert.Equal(t, `password: "a: #b" # comment`, output)
+	})
+
+	t.Run("it should write multi-line values as an indented block scalar", func(t *testing.T) {
+		output, _ := escapeAt(t, "yaml", "tls:\n  cert: ENC[]\n", "line one\nline two\n")
+
+		assert.Equal(t, "tls:\n  cert: |\n    line one\n    line two\n", output)
+	})
+
+	t.Run("it should indent multi-line values inside an existing block scalar", func(t *testing.T) {
+		output, _ := escapeAt(t, "yaml", "cert: |\n    ENC[]\n", "line one\nline two")
+
+		assert.Equal(t, "cert: |\n    line one\n    line two\n", output)
+	})
+
+	t.Run("it should refuse values that cannot be made safe", func(t *testing.T) {
+		_, err := escapeAt(t, "yaml", "url: https://ENC[]@example.com", "pass word: x")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestEscapeShell(t *testing.T) {
+	t.Run("it should escape values for the quotes they sit in", func(t *testing.T) {
+		output, _ := escapeAt(t, "shell", `export A='ENC[]'`, "it's")
+		assert.Equal(t, `export A='it'\''s'`, output)
+
+		output, _ = escapeAt(t, "shell", `export A="ENC[]"`, "$HOME \"x\"")
+		assert.Equal(t, `export A="\$HOME \"x\""`, output)
+	})
+
+	t.Run("it should single quote bare values that need it", func(t *testing.T) {
+		output, _ := escapeAt(t, "shell", `export A=ENC[]`, "a b;c")
+
+		assert.Equal(t, `export A='a b;c'`, output)
+	})
+}
+
+func TestEscapeDotenv(t *testing.T) {
+	t.Run("it should escape values inside double quotes", func(t *testing.T) {
+		output, _ := escapeAt(t, "dotenv", `A="ENC[]"`, "x\"y\nz")
+
+		assert.Equal(t, `A="x\"y\nz"`, output)
+	})
+
+	t.Run("it should quote bare values that need it", func(t *testing.T) {
+		output, _ := escapeAt(t, "dotenv", `A=ENC[]`, "two words")
+
+		assert.Equal(t, `A="two words"`, output)
+	})
+}
+
+func TestDetectEscaping(t *testing.T) {
+	t.Run("it should detect the escaping from the file name", func(t *testing.T) {
+		assert.Equal(t, "yaml", DetectEscaping("values.yml"))
+		assert.Equal(t, "shell", DetectEscaping("deploy.sh"))
+		assert.Equal(t, "dotenv", DetectEscaping(".env"))
+		assert.Equal(t, "none", DetectEscaping("notes.txt"))
+	})
+}
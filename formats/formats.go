@@ -0,0 +1,311 @@
+// Package formats locates the values of structured documents (YAML, JSON, TOML and dotenv files)
+// so they can be replaced in place without re-serializing the document, which keeps comments,
+// ordering and formatting intact.
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Format string
+
+const (
+	YAML   Format = "yaml"
+	JSON   Format = "json"
+	TOML   Format = "toml"
+	Dotenv Format = "dotenv"
+)
+
+// Value is a scalar leaf of a structured document
+type Value struct {
+	Path  []string // Keys (and sequence indexes) leading to the value
+	Text  string   // The decoded value
+	Start int      // Byte offset of the value as written, including any quotes
+	End   int      // Byte offset just past the value as written
+	Quote byte     // The quote character the value was written with, zero when unquoted
+	Flow  bool     // YAML only, the value sits inside a flow collection ([...] or {...})
+
+	format Format
+}
+
+// Key returns the dotted path of the value, for example "db.hosts.0". Dots and backslashes within a key are
+// escaped with a backslash, so the key "kubernetes.io/name" of labels is "labels.kubernetes\.io/name".
+func (v Value) Key() string {
+	return joinPath(v.Path)
+}
+
+// joinPath joins path segments with dots, escaping the dots and backslashes within them the way Key does
+func joinPath(path []string) string {
+	escaped := make([]string, len(path))
+	for i, segment := range path {
+		escaped[i] = pathEscaper.Replace(segment)
+	}
+
+	return strings.Join(escaped, ".")
+}
+
+var pathEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`)
+
+// ParseFormat converts a user supplied format name into a Format
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "yaml", "yml":
+		return YAML, nil
+	case "json":
+		return JSON, nil
+	case "toml":
+		return TOML, nil
+	case "dotenv", "env":
+		return Dotenv, nil
+	}
+
+	return "", fmt.Errorf("unknown format \"%s\", expected yaml, json, toml or dotenv", name)
+}
+
+// DetectFormat works out the format of a file from its name
+func DetectFormat(filename string) (Format, error) {
+	base := strings.ToLower(filepath.Base(filename))
+	if base == ".env" || strings.HasPrefix(base, ".env.") {
+		return Dotenv, nil
+	}
+
+	if format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(base), ".")); err == nil {
+		return format, nil
+	}
+
+	return "", fmt.Errorf("unable to detect the format of \"%s\"", filename)
+}
+
+// FindValues parses the input and returns every scalar value in the order they appear
+func FindValues(format Format, input string) ([]Value, error) {
+	var values []Value
+	var err error
+
+	switch format {
+	case YAML:
+		values, err = findYAMLValues(input)
+	case JSON:
+		values, err = findJSONValues(input)
+	case TOML:
+		values, err = findTOMLValues(input)
+	case Dotenv:
+		values, err = findDotenvValues(input)
+	default:
+		return nil, fmt.Errorf("unsupported format \"%s\"", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", format, err)
+	}
+
+	for i := range values {
+		values[i].format = format
+	}
+
+	sort.SliceStable(values, func(i, j int) bool { return values[i].Start < values[j].Start })
+
+	return values, nil
+}
+
+// ReplaceValues calls fn for every value and, when fn reports a replacement, writes the
+// replacement in place of the value, quoted the way the document requires
+func ReplaceValues(input string, values []Value, fn func(Value) (string, bool, error)) (string, error) {
+	var sb strings.Builder
+	last := 0
+
+	for _, value := range values {
+		replacement, replace, err := fn(value)
+		if err != nil {
+			return "", err
+		}
+
+		if !replace {
+			continue
+		}
+
+		sb.WriteString(input[last:value.Start])
+		sb.WriteString(value.Render(replacement))
+		last = value.End
+	}
+
+	sb.WriteString(input[last:])
+
+	return sb.String(), nil
+}
+
+// Render formats a new value so it can be written where this value was, keeping the original quoting where possible
+func (v Value) Render(replacement string) string {
+	multiline := strings.ContainsAny(replacement, "\r\n")
+
+	switch v.format {
+	case YAML:
+		if v.Quote == '\'' && !multiline {
+			return "'" + strings.ReplaceAll(replacement, "'", "''") + "'"
+		}
+
+		if v.Quote != 0 || v.Flow || !yamlPlainSafe(replacement) {
+			return QuoteJSON(replacement)
+		}
+
+		return replacement
+	case TOML:
+		if v.Quote == '\'' && !multiline && !strings.Contains(replacement, "'") {
+			return "'" + replacement + "'"
+		}
+
+		return QuoteJSON(replacement)
+	case Dotenv:
+		if v.Quote == '\'' && !multiline && !strings.Contains(replacement, "'") {
+			return "'" + replacement + "'"
+		}
+
+		if v.Quote == '"' || !dotenvBareSafe(replacement) {
+			return QuoteDotenv(replacement)
+		}
+
+		return replacement
+	default:
+		return QuoteJSON(replacement)
+	}
+}
+
+// RenderValue formats a value to be written as a new entry of the format, quoting it only when needed
+func RenderValue(format Format, value string) string {
+	return Value{format: format}.Render(value)
+}
+
+// QuoteJSON returns the value as a JSON string. The result is also a valid YAML and TOML double quoted string.
+func QuoteJSON(value string) string {
+	buff := &bytes.Buffer{}
+	encoder := json.NewEncoder(buff)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+
+	return strings.TrimSuffix(buff.String(), "\n")
+}
+
+// QuoteDotenv returns the value as a double quoted dotenv value
+func QuoteDotenv(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// QuoteShell returns the value single quoted for POSIX shells
+func QuoteShell(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+var (
+	yamlPlainRegex    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_./+=,\[\]-]*$`)
+	yamlReservedRegex = regexp.MustCompile(`^(?i:y|n|yes|no|on|off|true|false|null)$`)
+	dotenvBareRegex   = regexp.MustCompile(`^[A-Za-z0-9_./+=,:@%\[\]-]*$`)
+)
+
+// yamlPlainSafe reports whether the value reads back as the same string when written as a YAML plain scalar
+func yamlPlainSafe(value string) bool {
+	return yamlPlainRegex.MatchString(value) && !yamlReservedRegex.MatchString(value)
+}
+
+func dotenvBareSafe(value string) bool {
+	return dotenvBareRegex.MatchString(value)
+}
+
+// Selector decides which values should be picked based on their path
+type Selector func(path []string) bool
+
+// GlobSelector picks values whose dotted path matches any of the patterns.
+// Each segment is matched like a file glob and a "**" segment matches any number of segments,
+// so "api.*" matches "api.key" and "**.password" matches a password key at any depth.
+// A dot within a key is escaped with a backslash, as in "labels.kubernetes\.io/name".
+func GlobSelector(patterns []string) Selector {
+	split := make([][]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			split = append(split, splitPattern(pattern))
+		}
+	}
+
+	return func(p []string) bool {
+		for _, pattern := range split {
+			if MatchSegments(pattern, p) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// splitPattern splits a dotted pattern on the dots that are not escaped. The escapes are kept, as path.Match
+// reads them as literal characters too.
+func splitPattern(pattern string) []string {
+	segments := []string{}
+	start := 0
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '.':
+			segments = append(segments, pattern[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(segments, pattern[start:])
+}
+
+// RegexSelector picks values whose dotted path, escaped like Key, matches the regular expression
+func RegexSelector(re *regexp.Regexp) Selector {
+	return func(p []string) bool {
+		return re.MatchString(joinPath(p))
+	}
+}
+
+// MatchSegments reports whether the name segments match the pattern segments.
+// Each segment is matched with path.Match and a "**" segment matches zero or more segments.
+func MatchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if MatchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// lineStarts returns the byte offset of the start of every line
+func lineStarts(input string) []int {
+	starts := []int{0}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	return starts
+}
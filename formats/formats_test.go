@@ -0,0 +1,115 @@
+package formats
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// valueTexts maps the dotted key of each value to its decoded text
+func valueTexts(values []Value) map[string]string {
+	texts := map[string]string{}
+	for _, v := range values {
+		texts[v.Key()] = v.Text
+	}
+
+	return texts
+}
+
+// replaceAll replaces every value whose key is in the replacements map
+func replaceAll(t *testing.T, format Format, input string, replacements map[string]string) string {
+	values, err := FindValues(format, input)
+	assert.Nil(t, err)
+
+	output, err := ReplaceValues(input, values, func(v Value) (string, bool, error) {
+		replacement, ok := replacements[v.Key()]
+		return replacement, ok, nil
+	})
+	assert.Nil(t, err)
+
+	return output
+}
+
+func TestDetectFormat(t *testing.T) {
+	t.Run("it should detect the format from the file extension", func(t *testing.T) {
+		for name, expected := range map[string]Format{
+			"values.yaml":       YAML,
+			"values.YML":        YAML,
+			"config/app.json":   JSON,
+			"Cargo.toml":        TOML,
+			".env":              Dotenv,
+			".env.production":   Dotenv,
+			"secrets/prod.env":  Dotenv,
+			"/abs/path/to/.env": Dotenv,
+		} {
+			format, err := DetectFormat(name)
+
+			assert.Nil(t, err, name)
+			assert.Equal(t, expected, format, name)
+		}
+	})
+
+	t.Run("it should return an error for unknown extensions", func(t *testing.T) {
+		_, err := DetectFormat("notes.txt")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestSelectors(t *testing.T) {
+	t.Run("it should match dotted paths with globs", func(t *testing.T) {
+		selector := GlobSelector([]string{"db.password", "api.*", "**.token"})
+
+		assert.True(t, selector([]string{"db", "password"}))
+		assert.True(t, selector([]string{"api", "key"}))
+		assert.True(t, selector([]string{"deeply", "nested", "token"}))
+		assert.True(t, selector([]string{"token"}))
+		assert.False(t, selector([]string{"db", "user"}))
+		assert.False(t, selector([]string{"api", "key", "id"}))
+	})
+
+	t.Run("it should match dotted paths with a regex", func(t *testing.T) {
+		selector := RegexSelector(regexp.MustCompile(`(^|\.)pass(word)?$`))
+
+		assert.True(t, selector([]string{"db", "pass"}))
+		assert.True(t, selector([]string{"password"}))
+		assert.False(t, selector([]string{"passage", "x"}))
+	})
+
+	t.Run("it should tell dots within keys from the dots between them", func(t *testing.T) {
+		selector := GlobSelector([]string{`labels.kubernetes\.io/*`})
+
+		assert.True(t, selector([]string{"labels", "kubernetes.io/name"}))
+		assert.False(t, selector([]string{"labels", "kubernetes", "io/name"}))
+		assert.Equal(t, `labels.kubernetes\.io/name`, Value{Path: []string{"labels", "kubernetes.io/name"}}.Key())
+		assert.Equal(t, `a\\b.c`, Value{Path: []string{`a\b`, "c"}}.Key())
+
+		regex := RegexSelector(regexp.MustCompile(`^labels\.kubernetes\\\.io/`))
+		assert.True(t, regex([]string{"labels", "kubernetes.io/name"}))
+		assert.False(t, regex([]string{"labels", "kubernetes", "io/name"}))
+	})
+}
+
+func TestRender(t *testing.T) {
+	t.Run("it should keep plain YAML values plain when they are safe", func(t *testing.T) {
+		assert.Equal(t, "ENC[KMS,abc=]", Value{format: YAML}.Render("ENC[KMS,abc=]"))
+		assert.Equal(t, `"yes"`, Value{format: YAML}.Render("yes"))
+		assert.Equal(t, `"a: b"`, Value{format: YAML}.Render("a: b"))
+		assert.Equal(t, `"ENC[KMS,abc=]"`, Value{format: YAML, Flow: true}.Render("ENC[KMS,abc=]"))
+		assert.Equal(t, `'it''s'`, Value{format: YAML, Quote: '\''}.Render("it's"))
+	})
+
+	t.Run("it should always quote JSON and TOML values", func(t *testing.T) {
+		assert.Equal(t, `"a\"b"`, Value{format: JSON}.Render(`a"b`))
+		assert.Equal(t, `"line\nbreak"`, Value{format: TOML}.Render("line\nbreak"))
+		assert.Equal(t, `'literal'`, Value{format: TOML, Quote: '\''}.Render("literal"))
+	})
+
+	t.Run("it should quote dotenv values only when needed", func(t *testing.T) {
+		assert.Equal(t, "ENC[KMS,abc=]", Value{format: Dotenv}.Render("ENC[KMS,abc=]"))
+		assert.Equal(t, `"two words"`, Value{format: Dotenv}.Render("two words"))
+		assert.Equal(t, `"say \"hi\"\n"`, Value{format: Dotenv, Quote: '"'}.Render("say \"hi\"\n"))
+		assert.Equal(t, `"${dragoman:KMS,abc=}"`, RenderValue(Dotenv, "${dragoman:KMS,abc=}"))
+	})
+}
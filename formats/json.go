@@ -0,0 +1,135 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+type jsonScanner struct {
+	input  string
+	pos    int
+	values []Value
+}
+
+func findJSONValues(input string) ([]Value, error) {
+	// Validate up front so the scanner below only has to deal with well formed input
+	if !json.Valid([]byte(input)) {
+		var v interface{}
+		return nil, json.Unmarshal([]byte(input), &v)
+	}
+
+	s := &jsonScanner{input: input, values: []Value{}}
+	if err := s.value([]string{}); err != nil {
+		return nil, err
+	}
+
+	return s.values, nil
+}
+
+func (s *jsonScanner) value(path []string) error {
+	s.skipSpace()
+
+	switch s.input[s.pos] {
+	case '{':
+		s.pos++
+		for {
+			s.skipSpace()
+			if s.input[s.pos] == '}' {
+				s.pos++
+				return nil
+			}
+
+			start := s.pos
+			s.skipString()
+
+			var key string
+			if err := json.Unmarshal([]byte(s.input[start:s.pos]), &key); err != nil {
+				return err
+			}
+
+			s.skipSpace()
+			s.pos++ // the colon
+
+			if err := s.value(appendPath(path, key)); err != nil {
+				return err
+			}
+
+			s.skipSpace()
+			if s.input[s.pos] == ',' {
+				s.pos++
+			}
+		}
+	case '[':
+		s.pos++
+		for i := 0; ; i++ {
+			s.skipSpace()
+			if s.input[s.pos] == ']' {
+				s.pos++
+				return nil
+			}
+
+			if err := s.value(appendPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+
+			s.skipSpace()
+			if s.input[s.pos] == ',' {
+				s.pos++
+			}
+		}
+	case '"':
+		start := s.pos
+		s.skipString()
+
+		var text string
+		if err := json.Unmarshal([]byte(s.input[start:s.pos]), &text); err != nil {
+			return err
+		}
+
+		s.values = append(s.values, Value{Path: path, Text: text, Start: start, End: s.pos, Quote: '"'})
+	default:
+		start := s.pos
+		for s.pos < len(s.input) && !isJSONDelimiter(s.input[s.pos]) {
+			s.pos++
+		}
+
+		if start == s.pos {
+			return fmt.Errorf("unexpected character at offset %d", start)
+		}
+
+		if text := s.input[start:s.pos]; text != "null" {
+			s.values = append(s.values, Value{Path: path, Text: text, Start: start, End: s.pos})
+		}
+	}
+
+	return nil
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.input) && isJSONDelimiter(s.input[s.pos]) && s.input[s.pos] <= ' ' {
+		s.pos++
+	}
+}
+
+// skipString moves past the string starting at the current position
+func (s *jsonScanner) skipString() {
+	for s.pos++; s.pos < len(s.input); s.pos++ {
+		switch s.input[s.pos] {
+		case '\\':
+			s.pos++
+		case '"':
+			s.pos++
+			return
+		}
+	}
+}
+
+func isJSONDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', ',', ':', ']', '}':
+		return true
+	}
+
+	return false
+}
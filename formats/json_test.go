@@ -0,0 +1,46 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONValues(t *testing.T) {
+	input := `{
+  "db": {"user": "admin", "password": "s3cr\"t", "port": 5432, "ssl": true, "replica": null},
+  "hosts": ["a", "b"]
+}`
+
+	t.Run("it should find every scalar with its path", func(t *testing.T) {
+		values, err := FindValues(JSON, input)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{
+			"db.user":     "admin",
+			"db.password": `s3cr"t`,
+			"db.port":     "5432",
+			"db.ssl":      "true",
+			"hosts.0":     "a",
+			"hosts.1":     "b",
+		}, valueTexts(values))
+	})
+
+	t.Run("it should replace values in place and keep everything else", func(t *testing.T) {
+		output := replaceAll(t, JSON, input, map[string]string{
+			"db.password": "ENC[KMS,cGFzcw==]",
+			"db.port":     "ENC[KMS,cG9ydA==]",
+		})
+
+		assert.Equal(t, `{
+  "db": {"user": "admin", "password": "ENC[KMS,cGFzcw==]", "port": "ENC[KMS,cG9ydA==]", "ssl": true, "replica": null},
+  "hosts": ["a", "b"]
+}`, output)
+	})
+
+	t.Run("it should return an error for invalid documents", func(t *testing.T) {
+		_, err := FindValues(JSON, `{"a": }`)
+
+		assert.Error(t, err)
+	})
+}
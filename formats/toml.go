@@ -0,0 +1,406 @@
+package formats
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var tomlDateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[ \t]\d{2}:`)
+
+type tomlScanner struct {
+	input  string
+	pos    int
+	values []Value
+	tables map[string]int // Number of entries seen for each array of tables
+}
+
+func findTOMLValues(input string) ([]Value, error) {
+	s := &tomlScanner{
+		input:  input,
+		values: []Value{},
+		tables: map[string]int{},
+	}
+
+	prefix := []string{}
+	for {
+		s.skipBlank()
+		if s.pos >= len(s.input) {
+			return s.values, nil
+		}
+
+		line := strings.Count(s.input[:s.pos], "\n") + 1
+
+		var err error
+		if s.input[s.pos] == '[' {
+			prefix, err = s.table()
+		} else if err = s.keyValue(prefix, false); err == nil {
+			err = s.endOfLine()
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
+
+// table parses a [table] or [[array of tables]] header and returns the path it opens
+func (s *tomlScanner) table() ([]string, error) {
+	array := strings.HasPrefix(s.input[s.pos:], "[[")
+	if array {
+		s.pos += 2
+	} else {
+		s.pos++
+	}
+
+	path, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+
+	s.skipSpace()
+	if !strings.HasPrefix(s.input[s.pos:], closing) {
+		return nil, fmt.Errorf("expected %s to close the table header", closing)
+	}
+	s.pos += len(closing)
+
+	if array {
+		name := strings.Join(path, "\x00")
+		path = append(path, strconv.Itoa(s.tables[name]))
+		s.tables[name]++
+	}
+
+	return path, s.endOfLine()
+}
+
+func (s *tomlScanner) keyValue(prefix []string, nested bool) error {
+	key, err := s.key()
+	if err != nil {
+		return err
+	}
+
+	s.skipSpace()
+	if s.pos >= len(s.input) || s.input[s.pos] != '=' {
+		return fmt.Errorf("expected = after the key")
+	}
+	s.pos++
+	s.skipSpace()
+
+	return s.value(append(append([]string{}, prefix...), key...), nested)
+}
+
+// value parses the value at the current position. Arrays and inline tables are descended into, with the elements
+// of arrays keyed by their index, so every scalar they hold is found. Bare values inside them end at a separator.
+func (s *tomlScanner) value(path []string, nested bool) error {
+	start := s.pos
+	value := Value{Path: path, Start: start}
+
+	switch {
+	case strings.HasPrefix(s.input[s.pos:], `"""`):
+		end := s.findClosing(s.pos+3, `"""`, true)
+		if end < 0 {
+			return fmt.Errorf("unterminated multi-line string")
+		}
+
+		value.Text = unescapeTOML(trimFirstNewline(s.input[start+3:end]), true)
+		value.Quote = '"'
+		s.pos = end + 3
+	case strings.HasPrefix(s.input[s.pos:], "'''"):
+		end := s.findClosing(s.pos+3, "'''", false)
+		if end < 0 {
+			return fmt.Errorf("unterminated multi-line literal string")
+		}
+
+		value.Text = trimFirstNewline(s.input[start+3 : end])
+		value.Quote = '\''
+		s.pos = end + 3
+	case s.pos < len(s.input) && s.input[s.pos] == '"':
+		end := s.findClosing(s.pos+1, `"`, true)
+		if end < 0 || strings.Contains(s.input[start:end], "\n") {
+			return fmt.Errorf("unterminated string")
+		}
+
+		value.Text = unescapeTOML(s.input[start+1:end], false)
+		value.Quote = '"'
+		s.pos = end + 1
+	case s.pos < len(s.input) && s.input[s.pos] == '\'':
+		end := s.findClosing(s.pos+1, "'", false)
+		if end < 0 || strings.Contains(s.input[start:end], "\n") {
+			return fmt.Errorf("unterminated literal string")
+		}
+
+		value.Text = s.input[start+1 : end]
+		value.Quote = '\''
+		s.pos = end + 1
+	case s.pos < len(s.input) && s.input[s.pos] == '[':
+		s.pos++
+		return s.array(path)
+	case s.pos < len(s.input) && s.input[s.pos] == '{':
+		s.pos++
+		return s.inlineTable(path)
+	default:
+		stops := "#\n"
+		if nested {
+			stops += ",]}"
+		}
+
+		for s.pos < len(s.input) && !strings.ContainsRune(stops, rune(s.input[s.pos])) {
+			s.pos++
+		}
+
+		value.Text = strings.TrimRight(s.input[start:s.pos], " \t\r")
+		if value.Text == "" {
+			return fmt.Errorf("missing value")
+		}
+
+		// Only a date followed by a time holds a space, anything else is a missing separator
+		if nested && strings.ContainsAny(value.Text, " \t") && !tomlDateTime.MatchString(value.Text) {
+			return fmt.Errorf("expected a separator after \"%s\"", strings.Fields(value.Text)[0])
+		}
+		s.pos = start + len(value.Text)
+	}
+
+	value.End = s.pos
+	s.values = append(s.values, value)
+
+	return nil
+}
+
+// array parses the elements of an array up to its closing bracket, which may span several lines
+func (s *tomlScanner) array(path []string) error {
+	for index := 0; ; index++ {
+		s.skipBlank()
+		if s.pos < len(s.input) && s.input[s.pos] == ']' {
+			s.pos++
+			return nil
+		}
+
+		if s.pos >= len(s.input) {
+			return fmt.Errorf("unterminated array")
+		}
+
+		if err := s.value(append(append([]string{}, path...), strconv.Itoa(index)), true); err != nil {
+			return err
+		}
+
+		s.skipBlank()
+		switch {
+		case s.pos < len(s.input) && s.input[s.pos] == ',':
+			s.pos++
+		case s.pos < len(s.input) && s.input[s.pos] == ']':
+			s.pos++
+			return nil
+		default:
+			return fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+// inlineTable parses the key/value pairs of an inline table up to its closing brace
+func (s *tomlScanner) inlineTable(path []string) error {
+	s.skipSpace()
+	if s.pos < len(s.input) && s.input[s.pos] == '}' {
+		s.pos++
+		return nil
+	}
+
+	for {
+		if err := s.keyValue(path, true); err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		switch {
+		case s.pos < len(s.input) && s.input[s.pos] == ',':
+			s.pos++
+		case s.pos < len(s.input) && s.input[s.pos] == '}':
+			s.pos++
+			return nil
+		default:
+			return fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// key parses a possibly dotted and quoted key
+func (s *tomlScanner) key() ([]string, error) {
+	parts := []string{}
+
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.input) {
+			return nil, fmt.Errorf("unexpected end of input in key")
+		}
+
+		switch s.input[s.pos] {
+		case '"':
+			end := s.findClosing(s.pos+1, `"`, true)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted key")
+			}
+
+			parts = append(parts, unescapeTOML(s.input[s.pos+1:end], false))
+			s.pos = end + 1
+		case '\'':
+			end := s.findClosing(s.pos+1, "'", false)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted key")
+			}
+
+			parts = append(parts, s.input[s.pos+1:end])
+			s.pos = end + 1
+		default:
+			start := s.pos
+			for s.pos < len(s.input) && isTOMLBareKey(s.input[s.pos]) {
+				s.pos++
+			}
+
+			if start == s.pos {
+				return nil, fmt.Errorf("invalid key")
+			}
+
+			parts = append(parts, s.input[start:s.pos])
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.input) || s.input[s.pos] != '.' {
+			return parts, nil
+		}
+		s.pos++
+	}
+}
+
+// findClosing returns the offset of the closing delimiter, honouring escapes in basic strings
+func (s *tomlScanner) findClosing(from int, delimiter string, escapes bool) int {
+	for i := from; i < len(s.input); i++ {
+		if escapes && s.input[i] == '\\' {
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(s.input[i:], delimiter) {
+			// Multi-line strings may end with up to two extra quotes that belong to the content
+			for len(delimiter) == 3 && strings.HasPrefix(s.input[i+1:], delimiter) {
+				i++
+			}
+
+			return i
+		}
+	}
+
+	return -1
+}
+
+// skipBlank moves past whitespace, blank lines and comments
+func (s *tomlScanner) skipBlank() {
+	for s.pos < len(s.input) {
+		switch s.input[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		case '#':
+			for s.pos < len(s.input) && s.input[s.pos] != '\n' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *tomlScanner) skipSpace() {
+	for s.pos < len(s.input) && (s.input[s.pos] == ' ' || s.input[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+// endOfLine makes sure nothing but a comment follows on the current line
+func (s *tomlScanner) endOfLine() error {
+	s.skipSpace()
+	if s.pos < len(s.input) && s.input[s.pos] == '#' {
+		for s.pos < len(s.input) && s.input[s.pos] != '\n' {
+			s.pos++
+		}
+	}
+
+	if s.pos < len(s.input) && s.input[s.pos] != '\n' && s.input[s.pos] != '\r' {
+		return fmt.Errorf("unexpected text after value")
+	}
+
+	return nil
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func trimFirstNewline(value string) string {
+	if strings.HasPrefix(value, "\r\n") {
+		return value[2:]
+	}
+
+	return strings.TrimPrefix(value, "\n")
+}
+
+// unescapeTOML decodes the escape sequences of a basic string
+func unescapeTOML(value string, multiline bool) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 >= len(value) {
+			sb.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		switch value[i] {
+		case 'b':
+			sb.WriteByte('\b')
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'u', 'U':
+			size := 4
+			if value[i] == 'U' {
+				size = 8
+			}
+
+			if code, err := strconv.ParseUint(value[i+1:min(i+1+size, len(value))], 16, 32); err == nil && i+size < len(value) {
+				sb.WriteRune(rune(code))
+				i += size
+			} else {
+				sb.WriteRune(utf8.RuneError)
+			}
+		case ' ', '\t', '\r', '\n':
+			// A line ending backslash trims all whitespace up to the next content
+			if multiline {
+				for i+1 < len(value) && strings.ContainsRune(" \t\r\n", rune(value[i+1])) {
+					i++
+				}
+			}
+		default:
+			sb.WriteByte(value[i])
+		}
+	}
+
+	return sb.String()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
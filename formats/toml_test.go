@@ -0,0 +1,123 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOMLValues(t *testing.T) {
+	input := `# Settings
+title = "app" # inline comment
+ports = [ 8000,
+  8001 ]
+
+[db]
+user = 'admin'
+"pass.word" = "s3cr\"t"
+timeout = 30
+
+[[servers]]
+name = """
+alpha"""
+
+[[servers]]
+name = "beta"
+`
+
+	t.Run("it should find every scalar with its path", func(t *testing.T) {
+		values, err := FindValues(TOML, input)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{
+			"title":          "app",
+			"ports.0":        "8000",
+			"ports.1":        "8001",
+			"db.user":        "admin",
+			`db.pass\.word`:  `s3cr"t`,
+			"db.timeout":     "30",
+			"servers.0.name": "alpha",
+			"servers.1.name": "beta",
+		}, valueTexts(values))
+	})
+
+	t.Run("it should replace values in place and keep everything else", func(t *testing.T) {
+		output := replaceAll(t, TOML, input, map[string]string{
+			"db.user":        "ENC[KMS,dXNlcg==]",
+			"db.timeout":     "ENC[KMS,dGltZQ==]",
+			"servers.0.name": "ENC[KMS,bmFtZQ==]",
+		})
+
+		assert.Equal(t, `# Settings
+title = "app" # inline comment
+ports = [ 8000,
+  8001 ]
+
+[db]
+user = 'ENC[KMS,dXNlcg==]'
+"pass.word" = "s3cr\"t"
+timeout = "ENC[KMS,dGltZQ==]"
+
+[[servers]]
+name = "ENC[KMS,bmFtZQ==]"
+
+[[servers]]
+name = "beta"
+`, output)
+	})
+
+	t.Run("it should return an error for invalid documents", func(t *testing.T) {
+		_, err := FindValues(TOML, "key = \"unterminated\n")
+		assert.Error(t, err)
+
+		_, err = FindValues(TOML, "key = [ 1, 2\n")
+		assert.Error(t, err)
+
+		_, err = FindValues(TOML, "key = { a = 1 b = 2 }\n")
+		assert.Error(t, err)
+	})
+}
+
+func TestTOMLCollections(t *testing.T) {
+	input := `db = { password = "x", port = 5432, opts = { tls = 'on' } }
+hosts = [
+  "a", # first
+  { name = "b", token = "y" },
+  [ 1, 2 ],
+]
+empty = {}
+`
+
+	t.Run("it should find the scalars of inline tables and arrays", func(t *testing.T) {
+		values, err := FindValues(TOML, input)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{
+			"db.password":   "x",
+			"db.port":       "5432",
+			"db.opts.tls":   "on",
+			"hosts.0":       "a",
+			"hosts.1.name":  "b",
+			"hosts.1.token": "y",
+			"hosts.2.0":     "1",
+			"hosts.2.1":     "2",
+		}, valueTexts(values))
+	})
+
+	t.Run("it should replace values inside collections", func(t *testing.T) {
+		output := replaceAll(t, TOML, input, map[string]string{
+			"db.password":   "ENC[KMS,cGFzcw==]",
+			"db.port":       "ENC[KMS,cG9ydA==]",
+			"hosts.1.token": "ENC[KMS,dG9rZW4=]",
+		})
+
+		assert.Equal(t, `db = { password = "ENC[KMS,cGFzcw==]", port = "ENC[KMS,cG9ydA==]", opts = { tls = 'on' } }
+hosts = [
+  "a", # first
+  { name = "b", token = "ENC[KMS,dG9rZW4=]" },
+  [ 1, 2 ],
+]
+empty = {}
+`, output)
+	})
+}
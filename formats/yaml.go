@@ -0,0 +1,210 @@
+package formats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+type yamlScanner struct {
+	input  string
+	starts []int
+	values []Value
+}
+
+func findYAMLValues(input string) ([]Value, error) {
+	s := &yamlScanner{
+		input:  input,
+		starts: lineStarts(input),
+		values: []Value{},
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		for _, node := range doc.Content {
+			if err := s.walk(node, []string{}, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s.values, nil
+}
+
+func (s *yamlScanner) walk(node *yaml.Node, path []string, flow bool) error {
+	flow = flow || node.Style&yaml.FlowStyle != 0
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "<<" {
+				continue
+			}
+
+			if err := s.walk(node.Content[i+1], appendPath(path, key), flow); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := s.walk(item, appendPath(path, strconv.Itoa(i)), flow); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil
+		}
+
+		start, end, err := s.extent(node)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", node.Line, err)
+		}
+
+		value := Value{
+			Path:  path,
+			Text:  node.Value,
+			Start: start,
+			End:   end,
+			Flow:  flow,
+		}
+
+		switch {
+		case node.Style&yaml.DoubleQuotedStyle != 0:
+			value.Quote = '"'
+		case node.Style&yaml.SingleQuotedStyle != 0:
+			value.Quote = '\''
+		}
+
+		s.values = append(s.values, value)
+	}
+
+	// Aliases point at values that are found where their anchor is defined
+	return nil
+}
+
+// extent finds the byte range of a scalar as written in the source
+func (s *yamlScanner) extent(node *yaml.Node) (int, int, error) {
+	if node.Line < 1 || node.Line > len(s.starts) {
+		return 0, 0, fmt.Errorf("unable to locate the value")
+	}
+
+	// Columns are counted in characters, not bytes
+	start := s.starts[node.Line-1]
+	for col := 1; col < node.Column && start < len(s.input); col++ {
+		_, size := utf8.DecodeRuneInString(s.input[start:])
+		start += size
+	}
+
+	// Skip over any anchor or tag in front of the value
+	for start < len(s.input) && (s.input[start] == '&' || s.input[start] == '!') {
+		for start < len(s.input) && !isYAMLSpace(s.input[start]) {
+			start++
+		}
+
+		for start < len(s.input) && isYAMLSpace(s.input[start]) {
+			start++
+		}
+	}
+
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(s.input); i++ {
+			switch s.input[i] {
+			case '\\':
+				i++
+			case '"':
+				return start, i + 1, nil
+			}
+		}
+
+		return 0, 0, fmt.Errorf("unterminated double quoted value")
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(s.input); i++ {
+			if s.input[i] == '\'' {
+				if i+1 < len(s.input) && s.input[i+1] == '\'' {
+					i++
+					continue
+				}
+
+				return start, i + 1, nil
+			}
+		}
+
+		return 0, 0, fmt.Errorf("unterminated single quoted value")
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return start, s.blockEnd(start), nil
+	default:
+		if !strings.HasPrefix(s.input[start:], node.Value) {
+			return 0, 0, fmt.Errorf("multi-line plain values are not supported, quote the value instead")
+		}
+
+		return start, start + len(node.Value), nil
+	}
+}
+
+// blockEnd finds the end of a literal or folded block scalar starting at its indicator.
+// The block runs for as long as lines are blank or at least as indented as its first content line.
+func (s *yamlScanner) blockEnd(indicator int) int {
+	end := strings.IndexByte(s.input[indicator:], '\n')
+	if end < 0 {
+		return len(s.input)
+	}
+	end += indicator
+
+	blockIndent := -1
+	for pos := end + 1; pos < len(s.input); {
+		lineEnd := strings.IndexByte(s.input[pos:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(s.input)
+		} else {
+			lineEnd += pos
+		}
+
+		line := s.input[pos:lineEnd]
+		content := strings.TrimLeft(line, " ")
+		if strings.TrimSpace(content) != "" {
+			indent := len(line) - len(content)
+			if blockIndent < 0 {
+				blockIndent = indent
+			}
+
+			if indent < blockIndent || blockIndent == 0 {
+				break
+			}
+
+			end = lineEnd
+		}
+
+		pos = lineEnd + 1
+	}
+
+	return end
+}
+
+func isYAMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// appendPath copies the path so sibling values never share a backing array
+func appendPath(path []string, segment string) []string {
+	p := make([]string, len(path), len(path)+1)
+	copy(p, path)
+
+	return append(p, segment)
+}
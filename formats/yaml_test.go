@@ -0,0 +1,73 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestYAMLValues(t *testing.T) {
+	input := `# Database settings
+db:
+  user: admin # the user
+  password: "s3cr\"t"
+  port: 5432
+  hosts: [a.example.com, 'b.example.com']
+api:
+  key: &key plain-key
+  alias: *key
+  cert: |
+    line one
+    line two
+
+  empty:
+other: value
+`
+
+	t.Run("it should find every scalar with its path", func(t *testing.T) {
+		values, err := FindValues(YAML, input)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{
+			"db.user":     "admin",
+			"db.password": `s3cr"t`,
+			"db.port":     "5432",
+			"db.hosts.0":  "a.example.com",
+			"db.hosts.1":  "b.example.com",
+			"api.key":     "plain-key",
+			"api.cert":    "line one\nline two\n",
+			"other":       "value",
+		}, valueTexts(values))
+	})
+
+	t.Run("it should replace values in place and keep everything else", func(t *testing.T) {
+		output := replaceAll(t, YAML, input, map[string]string{
+			"db.user":     "ENC[KMS,dXNlcg==]",
+			"db.password": "ENC[KMS,cGFzcw==]",
+			"db.hosts.1":  "ENC[KMS,aG9zdA==]",
+			"api.key":     "ENC[KMS,a2V5]",
+			"api.cert":    "ENC[KMS,Y2VydA==]",
+		})
+
+		assert.Equal(t, `# Database settings
+db:
+  user: ENC[KMS,dXNlcg==] # the user
+  password: "ENC[KMS,cGFzcw==]"
+  port: 5432
+  hosts: [a.example.com, 'ENC[KMS,aG9zdA==]']
+api:
+  key: &key ENC[KMS,a2V5]
+  alias: *key
+  cert: ENC[KMS,Y2VydA==]
+
+  empty:
+other: value
+`, output)
+	})
+
+	t.Run("it should return an error for invalid documents", func(t *testing.T) {
+		_, err := FindValues(YAML, "a: [unclosed")
+
+		assert.Error(t, err)
+	})
+}